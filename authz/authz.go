@@ -0,0 +1,165 @@
+// Package authz decides who may read from and write to a conversation.
+// Every handler that touches a group or 1‑on‑1 conversation goes through
+// here so the rules (and the 403/404 responses) are the same everywhere.
+package authz
+
+import (
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+
+	"chat-app/models"
+)
+
+var (
+	ErrGroupNotFound    = errors.New("group not found")
+	ErrNotMember        = errors.New("not a member of this group")
+	ErrReceiverNotFound = errors.New("receiver not found")
+	ErrNoConversation   = errors.New("either group_id or receiver_id required")
+	ErrBothConversation = errors.New("only one of group_id or receiver_id allowed")
+	ErrNotAccountOwner  = errors.New("you can only modify your own account")
+	ErrNotAdmin         = errors.New("administrators only")
+	ErrGroupRole        = errors.New("your group role does not allow this")
+	ErrMessageNotFound  = errors.New("message not found")
+)
+
+// Status maps an error returned by this package to an HTTP status code.
+// Anything else (database errors) is a 500.
+func Status(err error) int {
+	switch {
+	case errors.Is(err, ErrGroupNotFound), errors.Is(err, ErrReceiverNotFound), errors.Is(err, ErrMessageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNotMember), errors.Is(err, ErrNotAccountOwner), errors.Is(err, ErrGroupRole),
+		errors.Is(err, ErrNotAdmin):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNoConversation), errors.Is(err, ErrBothConversation):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Group checks that the group exists (and is not soft-deleted) and that
+// userID is one of its members.
+func Group(db *gorm.DB, groupID, userID string) error {
+	var group models.ChatGroup
+	if err := db.Select("id").First(&group, "id = ?", groupID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrGroupNotFound
+		}
+		return err
+	}
+
+	var count int64
+	if err := db.Model(&models.GroupMember{}).
+		Where("group_id = ? AND user_id = ?", groupID, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// GroupRole is like Group but also requires the member to have at least the
+// role minRole. It returns the caller's membership row.
+func GroupRole(db *gorm.DB, groupID, userID, minRole string) (*models.GroupMember, error) {
+	if err := Group(db, groupID, userID); err != nil {
+		return nil, err
+	}
+	var member models.GroupMember
+	if err := db.First(&member, "group_id = ? AND user_id = ?", groupID, userID).Error; err != nil {
+		return nil, err
+	}
+	if models.GroupRoleRank(member.Role) < models.GroupRoleRank(minRole) {
+		return nil, ErrGroupRole
+	}
+	return &member, nil
+}
+
+// Direct checks that the other side of a 1‑on‑1 conversation exists.
+func Direct(db *gorm.DB, receiverID string) error {
+	var count int64
+	if err := db.Model(&models.User{}).Where("id = ?", receiverID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrReceiverNotFound
+	}
+	return nil
+}
+
+// Conversation checks access to a group (groupID set) or 1‑on‑1
+// conversation (receiverID set). Exactly one of them must be given.
+func Conversation(db *gorm.DB, userID string, groupID, receiverID *string) error {
+	switch {
+	case groupID != nil && receiverID != nil:
+		return ErrBothConversation
+	case groupID != nil:
+		return Group(db, *groupID, userID)
+	case receiverID != nil:
+		return Direct(db, *receiverID)
+	default:
+		return ErrNoConversation
+	}
+}
+
+// Message loads a message and checks userID can see it: a member of its
+// group, or the sender or receiver of a 1‑on‑1 message. Messages in DMs the
+// user isn't part of are reported as not found.
+func Message(db *gorm.DB, msgID, userID string) (*models.Message, error) {
+	var msg models.Message
+	if err := db.First(&msg, "id = ?", msgID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if msg.GroupID != nil {
+		if err := Group(db, *msg.GroupID, userID); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	}
+	if msg.SenderID != userID && (msg.ReceiverID == nil || *msg.ReceiverID != userID) {
+		return nil, ErrMessageNotFound
+	}
+	return &msg, nil
+}
+
+// IsAdmin reports whether userID has the system administrator role.
+func IsAdmin(db *gorm.DB, userID string) (bool, error) {
+	var count int64
+	err := db.Model(&models.User{}).Where("id = ? AND role = ?", userID, models.RoleAdmin).Count(&count).Error
+	return count > 0, err
+}
+
+// Admin checks that userID is a system administrator.
+func Admin(db *gorm.DB, userID string) error {
+	admin, err := IsAdmin(db, userID)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return ErrNotAdmin
+	}
+	return nil
+}
+
+// Account checks that actorID may modify the account targetID: only the
+// user themselves or an administrator can.
+func Account(db *gorm.DB, actorID, targetID string) error {
+	if actorID == targetID {
+		return nil
+	}
+	admin, err := IsAdmin(db, actorID)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return ErrNotAccountOwner
+	}
+	return nil
+}
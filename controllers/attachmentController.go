@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chat-app/authz"
+	"chat-app/media"
+	"chat-app/models"
+	"chat-app/storage"
+)
+
+const (
+	// maxAttachmentsPerMessage limits attachment_ids on a single message
+	maxAttachmentsPerMessage = 10
+	// signedURLTTL is how long a redirect to the object store stays valid
+	signedURLTTL = 5 * time.Minute
+)
+
+var errInvalidAttachment = errors.New("unknown attachment or already sent")
+
+type AttachmentController struct {
+	DB         *gorm.DB
+	Storage    storage.Storage
+	Thumbnails *media.Thumbnailer
+	MaxSize    int64 // bytes
+}
+
+func NewAttachmentController(db *gorm.DB, store storage.Storage, thumbs *media.Thumbnailer) *AttachmentController {
+	maxMB := int64(25)
+	if v, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_MB"), 10, 64); err == nil && v > 0 {
+		maxMB = v
+	}
+	return &AttachmentController{DB: db, Storage: store, Thumbnails: thumbs, MaxSize: maxMB << 20}
+}
+
+// Upload (POST /api/attachments) — multipart form with a "file" field.
+// Returns the attachment; send its ID in attachment_ids on POST /api/messages.
+func (ac *AttachmentController) Upload(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	// a little slack for the multipart envelope
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, ac.MaxSize+1<<20)
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if fh.Size > ac.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+		return
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	// don't trust the client's Content-Type, look at the bytes
+	mt, err := mimetype.DetectReader(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not read file"})
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	att := models.Attachment{
+		ID:         uuid.NewString(),
+		UploaderID: userID,
+		FileName:   cleanFileName(fh.Filename),
+		MimeType:   mt.String(),
+		Size:       fh.Size,
+	}
+	att.StorageKey = path.Join("attachments", att.ID[:2], att.ID+mt.Extension())
+
+	var body io.Reader = f
+	if media.IsImage(att.MimeType) {
+		// images are stored without their EXIF block (GPS position, camera
+		// serial, ...) and most get thumbnails in the background
+		data, err := io.ReadAll(f)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "could not read file"})
+			return
+		}
+		clean, w, h, err := media.Sanitize(att.MimeType, data)
+		if errors.Is(err, media.ErrTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image"})
+			return
+		}
+		body = bytes.NewReader(clean)
+		att.Size = int64(len(clean))
+		att.Width, att.Height = w, h
+		if media.Thumbnailable(att.MimeType, clean) {
+			att.ThumbnailStatus = models.ThumbnailPending
+		}
+	}
+
+	if err := ac.Storage.Put(c.Request.Context(), att.StorageKey, body, att.Size, att.MimeType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store file"})
+		return
+	}
+	if err := ac.DB.Create(&att).Error; err != nil {
+		ac.Storage.Delete(c.Request.Context(), att.StorageKey)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if att.ThumbnailStatus == models.ThumbnailPending {
+		ac.Thumbnails.Enqueue(att.ID)
+	}
+
+	att.AfterFind(ac.DB)
+	att.Thumbnails = []models.AttachmentThumbnail{}
+	c.JSON(http.StatusCreated, att)
+}
+
+// Download (GET /api/attachments/:id/download) — only participants of the
+// conversation the file was sent to (or its uploader, before it is sent).
+// Redirects to a short-lived signed URL when the storage supports it.
+func (ac *AttachmentController) Download(c *gin.Context) {
+	att, ok := ac.find(c)
+	if !ok {
+		return
+	}
+	ac.serve(c, att.StorageKey, att.MimeType, att.FileName)
+}
+
+// Thumbnail (GET /api/attachments/:id/thumbnails/:size) — same access rules
+// as Download. 404 until the thumbnail has been generated.
+func (ac *AttachmentController) Thumbnail(c *gin.Context) {
+	att, ok := ac.find(c)
+	if !ok {
+		return
+	}
+
+	var thumb models.AttachmentThumbnail
+	if err := ac.DB.First(&thumb, "attachment_id = ? AND size = ?", att.ID, c.Param("size")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "thumbnail not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ext := path.Ext(thumb.StorageKey)
+	name := strings.TrimSuffix(att.FileName, path.Ext(att.FileName)) + "-" + thumb.Size + ext
+	ac.serve(c, thumb.StorageKey, thumb.MimeType, name)
+}
+
+// find loads the attachment in the :id path parameter and checks the caller
+// may see it. On failure the response has been written.
+func (ac *AttachmentController) find(c *gin.Context) (*models.Attachment, bool) {
+	userID := c.GetString("userID")
+
+	var att models.Attachment
+	if err := ac.DB.First(&att, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if att.MessageID == nil {
+		if att.UploaderID != userID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return nil, false
+		}
+	} else if _, err := authz.Message(ac.DB, *att.MessageID, userID); err != nil {
+		abortWithAuthzError(c, err)
+		return nil, false
+	}
+	return &att, true
+}
+
+// serve sends a stored object to the client, via a signed URL if possible.
+// Either way files that could run script are only offered as downloads.
+func (ac *AttachmentController) serve(c *gin.Context, key, mimeType, fileName string) {
+	ctx := c.Request.Context()
+
+	disposition := "attachment"
+	if inlineSafe(mimeType) {
+		disposition = "inline"
+	}
+	disposition = mime.FormatMediaType(disposition, map[string]string{"filename": fileName})
+
+	if u, err := ac.Storage.SignedURL(ctx, key, signedURLTTL, mimeType, disposition); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if u != "" {
+		c.Redirect(http.StatusFound, u)
+		return
+	}
+
+	rc, err := ac.Storage.Open(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file missing from storage"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rc.Close()
+
+	c.Header("Content-Disposition", disposition)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.DataFromReader(http.StatusOK, -1, mimeType, rc, nil)
+}
+
+// linkAttachments attaches the uploader's not-yet-sent attachments ids to
+// msg. All of them must exist, belong to uploaderID and be unsent.
+func linkAttachments(tx *gorm.DB, msg *models.Message, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	res := tx.Model(&models.Attachment{}).
+		Where("id IN ? AND uploader_id = ? AND message_id IS NULL", ids, msg.SenderID).
+		Update("message_id", msg.ID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != int64(len(ids)) {
+		return errInvalidAttachment
+	}
+	return tx.Preload("Thumbnails").Where("message_id = ?", msg.ID).Find(&msg.Attachments).Error
+}
+
+// inlineSafe reports whether a file of this type may be shown inline by
+// browsers. Anything that could run script (HTML, SVG, ...) is downloaded.
+func inlineSafe(mimeType string) bool {
+	switch {
+	case strings.HasPrefix(mimeType, "image/svg"):
+		return false
+	case strings.HasPrefix(mimeType, "image/"),
+		strings.HasPrefix(mimeType, "video/"),
+		strings.HasPrefix(mimeType, "audio/"),
+		mimeType == "application/pdf":
+		return true
+	default:
+		return false
+	}
+}
+
+// cleanFileName keeps only the base name of an uploaded file.
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	if len(name) > 255 {
+		name = name[len(name)-255:]
+	}
+	return name
+}
@@ -0,0 +1,13 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"chat-app/authz"
+)
+
+// abortWithAuthzError writes the response for an error returned by the authz
+// package: 400/403/404 for access problems, 500 for anything else.
+func abortWithAuthzError(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(authz.Status(err), gin.H{"error": err.Error()})
+}
@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"chat-app/authz"
+	"chat-app/models"
+	"chat-app/realtime"
+)
+
+// previewLength is how much of the last message is shown in the inbox.
+const previewLength = 140
+
+type ConversationController struct {
+	DB  *gorm.DB
+	Hub *realtime.Hub
+}
+
+func NewConversationController(db *gorm.DB, hub *realtime.Hub) *ConversationController {
+	return &ConversationController{DB: db, Hub: hub}
+}
+
+// conversation is one entry of the inbox: a group or a 1‑on‑1 chat.
+type conversation struct {
+	Type           string          `json:"type"` // "group" or "direct"
+	ID             string          `json:"id"`   // group ID, or the other user's ID
+	Name           string          `json:"name"` // group name, or the other user's username
+	LastMessage    *messagePreview `json:"last_message"`
+	LastActivityAt time.Time       `json:"last_activity_at"`
+	UnreadCount    int64           `json:"unread_count"`
+}
+
+type messagePreview struct {
+	ID             string    `json:"id"`
+	SenderID       string    `json:"sender_id"`
+	SenderName     string    `json:"sender_name"`
+	Content        string    `json:"content"` // truncated to previewLength runes
+	HasAttachments bool      `json:"has_attachments"`
+	SentAt         time.Time `json:"sent_at"`
+}
+
+// key identifies a conversation in cursors.
+func (cv *conversation) key() string {
+	return cv.Type + ":" + cv.ID
+}
+
+// GetConversations (GET /api/conversations) — every group the caller is a
+// member of and everyone they have 1‑on‑1 messages with, most recently
+// active first. Thread replies don't count as activity, same as in
+// GET /api/messages. Paginate with ?limit= and ?before=next_cursor; the
+// inbox only pages backwards, so ?after= is rejected.
+func (cc *ConversationController) GetConversations(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	limit, before, after, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if after != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "after is not supported here, page with before"})
+		return
+	}
+
+	convs, err := cc.list(userID, limit+1, before)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var next *string
+	if len(convs) > limit {
+		convs = convs[:limit]
+		last := convs[len(convs)-1]
+		cur := encodeCursor(last.LastActivityAt, last.key())
+		next = &cur
+	}
+
+	if err := cc.fillPage(userID, convs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if convs == nil {
+		convs = []*conversation{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversations": convs, "next_cursor": next})
+}
+
+type markReadInput struct {
+	GroupID    *string `json:"group_id"`
+	ReceiverID *string `json:"receiver_id"`
+	MessageID  string  `json:"message_id"` // optional: defaults to the latest message
+}
+
+// MarkConversationRead (POST /api/conversations/read) — marks every
+// top-level message of a group (group_id) or 1‑on‑1 conversation
+// (receiver_id) read, up to and including message_id.
+func (cc *ConversationController) MarkConversationRead(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var input markReadInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := authz.Conversation(cc.DB, userID, input.GroupID, input.ReceiverID); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	// any message of the conversation will do to scope the query
+	scope := &models.Message{SenderID: userID, GroupID: input.GroupID, ReceiverID: input.ReceiverID}
+	q := inConversation(cc.DB.Model(&models.Message{}), userID, scope)
+
+	var upTo models.Message
+	var err error
+	if input.MessageID != "" {
+		err = q.First(&upTo, "messages.id = ?", input.MessageID).Error
+	} else {
+		err = q.Order("messages.sent_at desc, messages.id desc").First(&upTo).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if input.MessageID != "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found in this conversation"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "nothing to mark as read"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	advanced, err := markReadUpTo(cc.DB, userID, &upTo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if advanced {
+		publishReceipt(cc.DB, cc.Hub, "message.read", userID, &upTo)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "marked as read", "last_read_message_id": upTo.ID})
+}
+
+// conversationsSQL selects every conversation of a user with its last
+// activity time: groups they are a member of (an empty group counts from
+// when they joined) and everyone they have 1‑on‑1 messages with. Its
+// parameters are the user ID, four times.
+const conversationsSQL = `
+	SELECT 'group' AS type, g.id AS id, g.name AS name, CONCAT('group:', g.id) AS conv_key,
+		COALESCE(MAX(m.sent_at), gm.joined_at) AS last_activity_at
+	FROM group_members gm
+	JOIN chat_groups g ON g.id = gm.group_id AND g.deleted_at IS NULL
+	LEFT JOIN messages m ON m.group_id = g.id AND m.thread_root_id IS NULL AND m.deleted_at IS NULL
+	WHERE gm.user_id = ? AND gm.deleted_at IS NULL
+	GROUP BY g.id, g.name, gm.joined_at
+	UNION ALL
+	SELECT 'direct', p.partner_id, '', CONCAT('direct:', p.partner_id), MAX(p.sent_at)
+	FROM (
+		SELECT CASE WHEN sender_id = ? THEN receiver_id ELSE sender_id END AS partner_id, sent_at
+		FROM messages
+		WHERE group_id IS NULL AND thread_root_id IS NULL AND deleted_at IS NULL
+			AND (sender_id = ? OR receiver_id = ?)
+	) p
+	GROUP BY p.partner_id`
+
+// list returns up to limit of userID's conversations older than before
+// (all when nil), most recently active first, with their last activity
+// time and group names. Partner names, previews and unread counts are
+// filled later by fillPage.
+func (cc *ConversationController) list(userID string, limit int, before *cursor) ([]*conversation, error) {
+	q := cc.DB.Table("("+conversationsSQL+") AS convs", userID, userID, userID, userID).
+		Select("type, id, name, last_activity_at")
+	if before != nil {
+		q = q.Where("last_activity_at < ? OR (last_activity_at = ? AND conv_key < ?)", before.At, before.At, before.ID)
+	}
+
+	var convs []*conversation
+	err := q.Order("last_activity_at DESC, conv_key DESC").Limit(limit).Scan(&convs).Error
+	return convs, err
+}
+
+// fillPage loads partner names, last message previews and unread counts
+// for the conversations in convs.
+func (cc *ConversationController) fillPage(userID string, convs []*conversation) error {
+	var groupIDs, partnerIDs []string
+	for _, cv := range convs {
+		if cv.Type == "group" {
+			groupIDs = append(groupIDs, cv.ID)
+		} else {
+			partnerIDs = append(partnerIDs, cv.ID)
+		}
+	}
+
+	names := make(map[string]string, len(partnerIDs))
+	if len(partnerIDs) > 0 {
+		var users []models.User
+		// deleted accounts still show up under their old name
+		if err := cc.DB.Unscoped().Select("id, username").Where("id IN ?", partnerIDs).Find(&users).Error; err != nil {
+			return err
+		}
+		for _, u := range users {
+			names[u.ID] = u.Username
+		}
+	}
+
+	unread, err := cc.unreadCounts(userID, groupIDs, partnerIDs)
+	if err != nil {
+		return err
+	}
+
+	last, err := cc.lastMessages(userID, groupIDs, partnerIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, cv := range convs {
+		if cv.Type == "group" {
+			cv.UnreadCount = unread["group:"+cv.ID]
+		} else {
+			cv.Name = names[cv.ID]
+			cv.UnreadCount = unread["direct:"+cv.ID]
+		}
+		if msg, ok := last[cv.key()]; ok {
+			cv.LastMessage = newMessagePreview(msg)
+		}
+	}
+	return nil
+}
+
+// lastMessages loads the newest top-level message of each group in
+// groupIDs and of each 1‑on‑1 conversation with partnerIDs, keyed like
+// conversation.key().
+func (cc *ConversationController) lastMessages(userID string, groupIDs, partnerIDs []string) (map[string]*models.Message, error) {
+	// a message is the last one when no newer one shares its conversation
+	const newer = "NOT EXISTS (SELECT 1 FROM messages n WHERE %s AND n.thread_root_id IS NULL AND n.deleted_at IS NULL " +
+		"AND (n.sent_at > messages.sent_at OR (n.sent_at = messages.sent_at AND n.id > messages.id)))"
+	last := make(map[string]*models.Message, len(groupIDs)+len(partnerIDs))
+
+	var msgs []models.Message
+	if len(groupIDs) > 0 {
+		if err := cc.DB.Preload("Sender").Preload("Attachments").
+			Where("messages.group_id IN ? AND messages.thread_root_id IS NULL", groupIDs).
+			Where(fmt.Sprintf(newer, "n.group_id = messages.group_id")).
+			Find(&msgs).Error; err != nil {
+			return nil, err
+		}
+		for i := range msgs {
+			last["group:"+*msgs[i].GroupID] = &msgs[i]
+		}
+	}
+	if len(partnerIDs) > 0 {
+		var direct []models.Message
+		if err := cc.DB.Preload("Sender").Preload("Attachments").
+			Where("messages.group_id IS NULL AND messages.thread_root_id IS NULL").
+			Where("(messages.sender_id = ? AND messages.receiver_id IN ?) OR (messages.receiver_id = ? AND messages.sender_id IN ?)",
+				userID, partnerIDs, userID, partnerIDs).
+			Where(fmt.Sprintf(newer, "n.group_id IS NULL AND "+
+				"((n.sender_id = messages.sender_id AND n.receiver_id = messages.receiver_id) OR "+
+				"(n.sender_id = messages.receiver_id AND n.receiver_id = messages.sender_id))")).
+			Find(&direct).Error; err != nil {
+			return nil, err
+		}
+		for i := range direct {
+			partner := direct[i].SenderID
+			if partner == userID && direct[i].ReceiverID != nil {
+				partner = *direct[i].ReceiverID
+			}
+			last["direct:"+partner] = &direct[i]
+		}
+	}
+	return last, nil
+}
+
+// unreadCounts counts the unread top-level messages of userID per
+// conversation, keyed like conversation.key().
+func (cc *ConversationController) unreadCounts(userID string, groupIDs, partnerIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+
+	var rows []struct {
+		ID     string
+		Unread int64
+	}
+	if len(groupIDs) > 0 {
+		if err := unreadMessages(cc.DB, userID, groupKeyExpr).
+			Select("messages.group_id AS id, COUNT(*) AS unread").
+			Where("messages.thread_root_id IS NULL AND messages.group_id IN ?", groupIDs).
+			Group("messages.group_id").
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			counts["group:"+r.ID] = r.Unread
+		}
+	}
+	if len(partnerIDs) > 0 {
+		rows = rows[:0]
+		if err := unreadMessages(cc.DB, userID, directKeyExpr).
+			Select("messages.sender_id AS id, COUNT(*) AS unread").
+			Where("messages.group_id IS NULL AND messages.thread_root_id IS NULL").
+			Where("messages.receiver_id = ? AND messages.sender_id IN ?", userID, partnerIDs).
+			Group("messages.sender_id").
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			counts["direct:"+r.ID] = r.Unread
+		}
+	}
+	return counts, nil
+}
+
+func newMessagePreview(msg *models.Message) *messagePreview {
+	content := msg.Content
+	if utf8.RuneCountInString(content) > previewLength {
+		content = string([]rune(content)[:previewLength]) + "…"
+	}
+	return &messagePreview{
+		ID:             msg.ID,
+		SenderID:       msg.SenderID,
+		SenderName:     msg.Sender.Username,
+		Content:        content,
+		HasAttachments: len(msg.Attachments) > 0,
+		SentAt:         msg.SentAt,
+	}
+}
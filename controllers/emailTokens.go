@@ -0,0 +1,400 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chat-app/mailer"
+	"chat-app/models"
+)
+
+const (
+	resetTokenTTL  = time.Hour
+	verifyTokenTTL = 48 * time.Hour
+	// emailResendInterval is how long to wait before mailing a user another
+	// token of the same kind.
+	emailResendInterval = time.Minute
+	mailTimeout         = 30 * time.Second
+	// maxMailJobs bounds the mail work (password reset lookups, SMTP
+	// sends) running in the background at once.
+	maxMailJobs = 8
+)
+
+var (
+	errEmailTokenInvalid = errors.New("invalid or expired token")
+	errEmailUnverified   = errors.New("verify your email address before sending messages")
+	errMailBusy          = errors.New("too many emails are being sent, try again later")
+)
+
+// emailClaims are carried by mailed tokens. Email pins the token to the
+// address it was sent to: it stops working once the account's email changes.
+type emailClaims struct {
+	UserID string `json:"uid"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// ForgotPassword (POST /api/password/forgot) — body {"email": ...}. Mails a
+// reset link if the address belongs to an account. The lookup and mailing
+// happen in the background and the response is the same either way, so
+// neither its content nor its timing tells who is registered. Requests
+// beyond maxMailJobs in flight are dropped.
+func (uc *UserController) ForgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if uc.acquireMailJob() {
+		go func() {
+			defer uc.releaseMailJob()
+			uc.mailPasswordReset(input.Email)
+		}()
+	} else {
+		log.Printf("password reset dropped: %v", errMailBusy)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "if the address is registered, a reset link has been sent to it"})
+}
+
+// mailPasswordReset mails a reset link to the account with this email, if
+// there is one. It runs as a mail job and sends in line. Errors can only be
+// logged: the request has been answered.
+func (uc *UserController) mailPasswordReset(email string) {
+	var user models.User
+	if err := uc.DB.First(&user, "email = ?", email).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("password reset lookup: %v", err)
+		}
+		return
+	}
+	msg, err := uc.tokenMail(&user, models.TokenPasswordReset)
+	if err != nil {
+		log.Printf("password reset for user %s: %v", user.ID, err)
+		return
+	}
+	if msg != nil {
+		uc.sendMail(*msg, models.TokenPasswordReset, user.ID)
+	}
+}
+
+// ResetPassword (POST /api/password/reset) — body {"token": ..., "password":
+// ...} with the token from the reset email. Sets the new password and signs
+// out every session of the account.
+func (uc *UserController) ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token"    binding:"required"`
+		Password string `json:"password" binding:"required,min=6,max=100"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	var revoked []string
+	err = uc.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := uc.consumeEmailToken(tx, input.Token, models.TokenPasswordReset)
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"password": string(hashed)}
+		if user.EmailVerifiedAt == nil {
+			// the link reached them, so the address is theirs
+			updates["email_verified_at"] = time.Now()
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := expireEmailTokens(tx, user.ID, models.TokenPasswordReset); err != nil {
+			return err
+		}
+		revoked, err = revokeSessions(tx, "user_id = ?", user.ID)
+		return err
+	})
+	if errors.Is(err, errEmailTokenInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	uc.closeSessions(revoked)
+	c.JSON(http.StatusOK, gin.H{"message": "password has been reset, please log in again"})
+}
+
+// SendVerification (POST /api/email/verification) — mails the caller a new
+// verification link for their current address.
+func (uc *UserController) SendVerification(c *gin.Context) {
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", c.GetString("userID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already verified"})
+		return
+	}
+
+	sent, err := uc.mailToken(&user, models.TokenVerifyEmail)
+	if errors.Is(err, errMailBusy) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !sent {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "a verification email was just sent, try again in a minute"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
+}
+
+// VerifyEmail (POST /api/email/verify) — body {"token": ...} with the token
+// from the verification email. Needs no login: the token identifies the
+// account.
+func (uc *UserController) VerifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := uc.consumeEmailToken(tx, input.Token, models.TokenVerifyEmail)
+		if err != nil {
+			return err
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+		return tx.Model(user).Update("email_verified_at", time.Now()).Error
+	})
+	if errors.Is(err, errEmailTokenInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+// mailToken issues a token of the given purpose and mails it to user in the
+// background. It reports false without sending anything if the user got one
+// less than emailResendInterval ago, and fails with errMailBusy when
+// maxMailJobs are already running.
+func (uc *UserController) mailToken(user *models.User, purpose string) (bool, error) {
+	if !uc.acquireMailJob() {
+		return false, errMailBusy
+	}
+	msg, err := uc.tokenMail(user, purpose)
+	if err != nil || msg == nil {
+		uc.releaseMailJob()
+		return false, err
+	}
+	go func() {
+		defer uc.releaseMailJob()
+		uc.sendMail(*msg, purpose, user.ID)
+	}()
+	return true, nil
+}
+
+// tokenMail issues a token of the given purpose and returns the email
+// carrying it, or nil if the user got one less than emailResendInterval ago.
+// The user row is locked for the check, so parallel requests issue one
+// token between them.
+func (uc *UserController) tokenMail(user *models.User, purpose string) (*mailer.Message, error) {
+	ttl := verifyTokenTTL
+	if purpose == models.TokenPasswordReset {
+		ttl = resetTokenTTL
+	}
+	var token string
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+			First(&models.User{}, "id = ?", user.ID).Error; err != nil {
+			return err
+		}
+		var recent int64
+		if err := tx.Model(&models.EmailToken{}).
+			Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, purpose, time.Now().Add(-emailResendInterval)).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent > 0 {
+			return nil
+		}
+		var err error
+		token, err = uc.issueEmailToken(tx, user, purpose, ttl)
+		return err
+	})
+	if err != nil || token == "" {
+		return nil, err
+	}
+
+	switch purpose {
+	case models.TokenPasswordReset:
+		return &mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\n"+
+				"Someone asked to reset the password of your account. Open this link within an hour to choose a new one:\n\n"+
+				"%s\n\n"+
+				"If that wasn't you, ignore this email; your password stays the same.\n",
+				user.Username, uc.appLink("/reset-password", token)),
+		}, nil
+	default:
+		return &mailer.Message{
+			To:      user.Email,
+			Subject: "Verify your email address",
+			Body: fmt.Sprintf("Hi %s,\n\n"+
+				"Please confirm this is your email address by opening this link within 48 hours:\n\n"+
+				"%s\n",
+				user.Username, uc.appLink("/verify-email", token)),
+		}, nil
+	}
+}
+
+// sendMail sends msg, giving up after mailTimeout. Failures are logged.
+func (uc *UserController) sendMail(msg mailer.Message, purpose, userID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+	if err := uc.Mailer.Send(ctx, msg); err != nil {
+		log.Printf("mail %s to user %s: %v", purpose, userID, err)
+	}
+}
+
+// acquireMailJob takes one of the maxMailJobs slots, reporting false when
+// none is free.
+func (uc *UserController) acquireMailJob() bool {
+	select {
+	case uc.mailJobs <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (uc *UserController) releaseMailJob() {
+	<-uc.mailJobs
+}
+
+// appLink builds a link into the web app (APP_URL) carrying token.
+func (uc *UserController) appLink(path, token string) string {
+	return uc.AppURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// issueEmailToken records a single-use token for user and returns it signed.
+func (uc *UserController) issueEmailToken(tx *gorm.DB, user *models.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	row := models.EmailToken{
+		JTI:       uuid.NewString(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return "", err
+	}
+
+	claims := emailClaims{
+		UserID: user.ID,
+		Email:  user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        row.JTI,
+			Audience:  jwt.ClaimStrings{purpose},
+			ExpiresAt: jwt.NewNumericDate(row.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   user.ID,
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(uc.SecretKey)
+}
+
+// consumeEmailToken checks a mailed token of the given purpose, marks it
+// used and returns its user. Fails with errEmailTokenInvalid if the token is
+// forged, expired, already used or was sent to an address the account no
+// longer has.
+func (uc *UserController) consumeEmailToken(tx *gorm.DB, token, purpose string) (*models.User, error) {
+	claims := &emailClaims{}
+	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return uc.SecretKey, nil
+	}, jwt.WithAudience(purpose), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !tkn.Valid || claims.ID == "" {
+		return nil, errEmailTokenInvalid
+	}
+
+	var row models.EmailToken
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&row, "jti = ?", claims.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errEmailTokenInvalid
+		}
+		return nil, err
+	}
+	if row.UsedAt != nil || row.Purpose != purpose || row.UserID != claims.UserID || row.ExpiresAt.Before(time.Now()) {
+		return nil, errEmailTokenInvalid
+	}
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", row.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errEmailTokenInvalid
+		}
+		return nil, err
+	}
+	if user.Email != claims.Email {
+		return nil, errEmailTokenInvalid
+	}
+
+	if err := tx.Model(&row).Update("used_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// expireEmailTokens makes the user's outstanding tokens of one purpose
+// unusable, e.g. older reset links once the password has changed.
+func expireEmailTokens(tx *gorm.DB, userID, purpose string) error {
+	return tx.Model(&models.EmailToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+// emailVerified reports whether the user has confirmed their email address.
+func emailVerified(db *gorm.DB, userID string) (bool, error) {
+	var count int64
+	err := db.Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NOT NULL", userID).
+		Count(&count).Error
+	return count > 0, err
+}
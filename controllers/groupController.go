@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
@@ -8,33 +9,41 @@ import (
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
+	"chat-app/authz"
 	"chat-app/models"
+	"chat-app/realtime"
 )
 
 type GroupController struct {
-	DB *gorm.DB
+	DB  *gorm.DB
+	Hub *realtime.Hub
 }
 
-func NewGroupController(db *gorm.DB) *GroupController {
-	return &GroupController{DB: db}
+func NewGroupController(db *gorm.DB, hub *realtime.Hub) *GroupController {
+	return &GroupController{DB: db, Hub: hub}
 }
 
 // CreateGroup (POST /api/groups)
 func (gc *GroupController) CreateGroup(c *gin.Context) {
 	var input struct {
-		Name string `json:"name" binding:"required"`
+		Name       string `json:"name" binding:"required"`
+		Visibility string `json:"visibility" binding:"omitempty,oneof=public private invite_only"`
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.Visibility == "" {
+		input.Visibility = models.GroupPublic
+	}
 
 	userID, _ := c.Get("userID")
 
 	group := models.ChatGroup{
-		ID:        uuid.NewString(),
-		Name:      input.Name,
-		CreatedBy: userID.(string),
+		ID:         uuid.NewString(),
+		Name:       input.Name,
+		Visibility: input.Visibility,
+		CreatedBy:  userID.(string),
 	}
 
 	if err := gc.DB.Create(&group).Error; err != nil {
@@ -45,6 +54,7 @@ func (gc *GroupController) CreateGroup(c *gin.Context) {
 	member := models.GroupMember{
 		GroupID:  group.ID,
 		UserID:   userID.(string),
+		Role:     models.GroupRoleOwner,
 		JoinedAt: time.Now(),
 	}
 	gc.DB.Create(&member)
@@ -53,9 +63,15 @@ func (gc *GroupController) CreateGroup(c *gin.Context) {
 }
 
 // GetGroups (GET /api/groups)
+// Lists public and private groups plus the invite-only groups the caller is
+// in. Member lists are only included where the caller may see them.
 func (gc *GroupController) GetGroups(c *gin.Context) {
+    userID := c.GetString("userID")
+
     var groups []models.ChatGroup
     if err := gc.DB.
+        Where("visibility <> ? OR id IN (?)", models.GroupInviteOnly,
+            gc.DB.Model(&models.GroupMember{}).Select("group_id").Where("user_id = ?", userID)).
         Preload("Members").
         Preload("Members.User").
         Find(&groups).Error; err != nil {
@@ -64,8 +80,15 @@ func (gc *GroupController) GetGroups(c *gin.Context) {
     }
 
     for gi := range groups {
+        isMember := false
         for mi := range groups[gi].Members {
             groups[gi].Members[mi].User.Password = ""
+            if groups[gi].Members[mi].UserID == userID {
+                isMember = true
+            }
+        }
+        if !isMember && groups[gi].Visibility != models.GroupPublic {
+            groups[gi].Members = nil
         }
     }
 
@@ -74,9 +97,21 @@ func (gc *GroupController) GetGroups(c *gin.Context) {
 
 
 // JoinGroup (POST /api/groups/:id/join)
+// public: joins right away. private: files a join request for the admins
+// (202). invite_only: needs an invite link (POST /api/invites/:token/join).
 func (gc *GroupController) JoinGroup(c *gin.Context) {
 	groupID := c.Param("id")
-	userID, _ := c.Get("userID")
+	userID := c.GetString("userID")
+
+	var group models.ChatGroup
+	if err := gc.DB.First(&group, "id = ?", groupID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	var existing models.GroupMember
 	if err := gc.DB.First(&existing, "group_id = ? AND user_id = ?", groupID, userID).Error; err == nil {
@@ -84,15 +119,45 @@ func (gc *GroupController) JoinGroup(c *gin.Context) {
 		return
 	}
 
-	member := models.GroupMember{
-		GroupID:  groupID,
-		UserID:   userID.(string),
-		JoinedAt: time.Now(),
+	switch group.Visibility {
+	case models.GroupInviteOnly:
+		// don't reveal more than a missing group would
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		return
+	case models.GroupPrivate:
+		var pending int64
+		gc.DB.Model(&models.GroupJoinRequest{}).
+			Where("group_id = ? AND user_id = ? AND status = ?", groupID, userID, models.JoinRequestPending).
+			Count(&pending)
+		if pending > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "join request already pending"})
+			return
+		}
+		req := models.GroupJoinRequest{
+			ID:      uuid.NewString(),
+			GroupID: groupID,
+			UserID:  userID,
+			Status:  models.JoinRequestPending,
+		}
+		if err := gc.DB.Create(&req).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if ids, err := groupAdminIDs(gc.DB, groupID); err == nil {
+			gc.Hub.Publish(ids, realtime.Event{
+				Type: "group.join_requested",
+				Data: gin.H{"group_id": groupID, "user_id": userID, "request_id": req.ID},
+			})
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "join request sent", "request_id": req.ID})
+		return
 	}
-	if err := gc.DB.Create(&member).Error; err != nil {
+
+	if err := addGroupMember(gc.DB, groupID, userID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	publishMembership(gc.DB, gc.Hub, groupID, "group.member_joined", userID)
 	c.JSON(http.StatusOK, gin.H{"message": "joined group"})
 }
 
@@ -101,30 +166,263 @@ func (gc *GroupController) LeaveGroup(c *gin.Context) {
 	groupID := c.Param("id")
 	userID, _ := c.Get("userID")
 
+	var member models.GroupMember
+	if err := gc.DB.First(&member, "group_id = ? AND user_id = ?", groupID, userID).Error; err == nil &&
+		member.Role == models.GroupRoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "transfer ownership before leaving the group"})
+		return
+	}
+
 	if err := gc.DB.Delete(&models.GroupMember{}, "group_id = ? AND user_id = ?", groupID, userID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	publishMembership(gc.DB, gc.Hub, groupID, "group.member_left", userID.(string), userID.(string))
 	c.JSON(http.StatusOK, gin.H{"message": "left group"})
 }
 
-// DeleteGroup (DELETE /api/groups/:id)
+// DeleteGroup (DELETE /api/groups/:id) — owner only
 func (gc *GroupController) DeleteGroup(c *gin.Context) {
 	groupID := c.Param("id")
 	userID, _ := c.Get("userID")
 
+	if _, err := authz.GroupRole(gc.DB, groupID, userID.(string), models.GroupRoleOwner); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+	group := models.ChatGroup{ID: groupID}
+
+	memberIDs, _ := groupMemberIDs(gc.DB, groupID)
+
+	gc.DB.Delete(&models.GroupMember{}, "group_id = ?", groupID)
+	gc.DB.Delete(&group)
+
+	gc.Hub.Publish(memberIDs, realtime.Event{Type: "group.deleted", Data: gin.H{"group_id": groupID}})
+
+	c.JSON(http.StatusOK, gin.H{"message": "group deleted"})
+}
+
+// UpdateGroup (PUT /api/groups/:id) — rename / change settings, admins and owner
+func (gc *GroupController) UpdateGroup(c *gin.Context) {
+	groupID := c.Param("id")
+	userID := c.GetString("userID")
+
+	var input struct {
+		Name       *string `json:"name" binding:"omitempty,min=1,max=100"`
+		Visibility *string `json:"visibility" binding:"omitempty,oneof=public private invite_only"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := authz.GroupRole(gc.DB, groupID, userID, models.GroupRoleAdmin); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
 	var group models.ChatGroup
 	if err := gc.DB.First(&group, "id = ?", groupID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if group.CreatedBy != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "only creator can delete group"})
+	if input.Name != nil {
+		group.Name = *input.Name
+	}
+	if input.Visibility != nil {
+		group.Visibility = *input.Visibility
+	}
+	if err := gc.DB.Save(&group).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	gc.DB.Delete(&models.GroupMember{}, "group_id = ?", groupID)
-	gc.DB.Delete(&group)
+	if ids, err := groupMemberIDs(gc.DB, groupID); err == nil {
+		gc.Hub.Publish(ids, realtime.Event{Type: "group.updated", Data: group})
+	}
+	c.JSON(http.StatusOK, group)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "group deleted"})
+// PromoteMember (POST /api/groups/:id/members/:userId/promote) — owner makes a member admin
+func (gc *GroupController) PromoteMember(c *gin.Context) {
+	gc.setMemberRole(c, models.GroupRoleMember, models.GroupRoleAdmin)
+}
+
+// DemoteMember (POST /api/groups/:id/members/:userId/demote) — owner makes an admin a member again
+func (gc *GroupController) DemoteMember(c *gin.Context) {
+	gc.setMemberRole(c, models.GroupRoleAdmin, models.GroupRoleMember)
+}
+
+func (gc *GroupController) setMemberRole(c *gin.Context, from, to string) {
+	groupID := c.Param("id")
+	targetID := c.Param("userId")
+	userID := c.GetString("userID")
+
+	if _, err := authz.GroupRole(gc.DB, groupID, userID, models.GroupRoleOwner); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	var target models.GroupMember
+	if err := gc.DB.First(&target, "group_id = ? AND user_id = ?", groupID, targetID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if target.Role != from {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "member is not " + from})
+		return
+	}
+
+	if err := gc.DB.Model(&target).Update("role", to).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	gc.publishRoleChange(groupID, targetID, to)
+	c.JSON(http.StatusOK, gin.H{"message": "role updated", "role": to})
+}
+
+// TransferOwnership (POST /api/groups/:id/transfer) — owner hands the group to
+// another member and becomes an admin.
+func (gc *GroupController) TransferOwnership(c *gin.Context) {
+	groupID := c.Param("id")
+	userID := c.GetString("userID")
+
+	var input struct {
+		UserID string `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you already own this group"})
+		return
+	}
+
+	owner, err := authz.GroupRole(gc.DB, groupID, userID, models.GroupRoleOwner)
+	if err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	err = gc.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.GroupMember{}).
+			Where("group_id = ? AND user_id = ?", groupID, input.UserID).
+			Update("role", models.GroupRoleOwner)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(owner).Update("role", models.GroupRoleAdmin).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	gc.publishRoleChange(groupID, input.UserID, models.GroupRoleOwner)
+	gc.publishRoleChange(groupID, userID, models.GroupRoleAdmin)
+	c.JSON(http.StatusOK, gin.H{"message": "ownership transferred"})
+}
+
+// KickMember (DELETE /api/groups/:id/members/:userId) — admins and owner can
+// remove anyone with a lower role.
+func (gc *GroupController) KickMember(c *gin.Context) {
+	groupID := c.Param("id")
+	targetID := c.Param("userId")
+	userID := c.GetString("userID")
+
+	actor, err := authz.GroupRole(gc.DB, groupID, userID, models.GroupRoleAdmin)
+	if err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	var target models.GroupMember
+	if err := gc.DB.First(&target, "group_id = ? AND user_id = ?", groupID, targetID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if models.GroupRoleRank(target.Role) >= models.GroupRoleRank(actor.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot remove a member with an equal or higher role"})
+		return
+	}
+
+	if err := gc.DB.Delete(&target).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	publishMembership(gc.DB, gc.Hub, groupID, "group.member_removed", targetID, targetID)
+	c.JSON(http.StatusOK, gin.H{"message": "member removed"})
+}
+
+func (gc *GroupController) publishRoleChange(groupID, userID, role string) {
+	ids, err := groupMemberIDs(gc.DB, groupID)
+	if err != nil {
+		return
+	}
+	gc.Hub.Publish(ids, realtime.Event{
+		Type: "group.member_role_changed",
+		Data: gin.H{"group_id": groupID, "user_id": userID, "role": role},
+	})
+}
+
+// addGroupMember adds userID to the group as a plain member. Someone who
+// left (or was removed) before gets their old, soft-deleted row back.
+func addGroupMember(db *gorm.DB, groupID, userID string) error {
+	res := db.Unscoped().Model(&models.GroupMember{}).
+		Where("group_id = ? AND user_id = ? AND deleted_at IS NOT NULL", groupID, userID).
+		Updates(map[string]interface{}{"deleted_at": nil, "role": models.GroupRoleMember, "joined_at": time.Now()})
+	if res.Error != nil || res.RowsAffected > 0 {
+		return res.Error
+	}
+	return db.Create(&models.GroupMember{
+		GroupID:  groupID,
+		UserID:   userID,
+		Role:     models.GroupRoleMember,
+		JoinedAt: time.Now(),
+	}).Error
+}
+
+// groupAdminIDs returns the user IDs of a group's admins and owner.
+func groupAdminIDs(db *gorm.DB, groupID string) ([]string, error) {
+	var ids []string
+	err := db.Model(&models.GroupMember{}).
+		Where("group_id = ? AND role IN ?", groupID, []string{models.GroupRoleOwner, models.GroupRoleAdmin}).
+		Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+// groupMemberIDs returns the user IDs of the current members of a group.
+func groupMemberIDs(db *gorm.DB, groupID string) ([]string, error) {
+	var ids []string
+	err := db.Model(&models.GroupMember{}).Where("group_id = ?", groupID).Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+// publishMembership tells the members of a group (plus any extra users, e.g.
+// someone who just left) that its membership changed.
+func publishMembership(db *gorm.DB, hub *realtime.Hub, groupID, eventType, userID string, extra ...string) {
+	ids, err := groupMemberIDs(db, groupID)
+	if err != nil {
+		return
+	}
+	hub.Publish(append(ids, extra...), realtime.Event{
+		Type: eventType,
+		Data: gin.H{"group_id": groupID, "user_id": userID},
+	})
 }
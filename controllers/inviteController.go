@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chat-app/authz"
+	"chat-app/models"
+	"chat-app/realtime"
+)
+
+// InviteController handles invite links and join requests for groups.
+type InviteController struct {
+	DB  *gorm.DB
+	Hub *realtime.Hub
+}
+
+func NewInviteController(db *gorm.DB, hub *realtime.Hub) *InviteController {
+	return &InviteController{DB: db, Hub: hub}
+}
+
+type createInviteInput struct {
+	ExpiresInHours *int `json:"expires_in_hours" binding:"omitempty,min=1,max=8760"`
+	MaxUses        int  `json:"max_uses"         binding:"omitempty,min=0"`
+}
+
+var (
+	errInviteInvalid = errors.New("invite is invalid or has expired")
+	errAlreadyMember = errors.New("already joined")
+)
+
+// CreateInvite (POST /api/groups/:id/invites) — admins and owner
+func (ic *InviteController) CreateInvite(c *gin.Context) {
+	groupID := c.Param("id")
+	userID := c.GetString("userID")
+
+	// body is optional: no expiry, unlimited uses
+	var input createInviteInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := authz.GroupRole(ic.DB, groupID, userID, models.GroupRoleAdmin); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	invite := models.GroupInvite{
+		ID:        uuid.NewString(),
+		GroupID:   groupID,
+		Token:     token,
+		CreatedBy: userID,
+		MaxUses:   input.MaxUses,
+	}
+	if input.ExpiresInHours != nil {
+		exp := time.Now().Add(time.Duration(*input.ExpiresInHours) * time.Hour)
+		invite.ExpiresAt = &exp
+	}
+
+	if err := ic.DB.Create(&invite).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, invite)
+}
+
+// GetInvites (GET /api/groups/:id/invites) — admins and owner, active invites only
+func (ic *InviteController) GetInvites(c *gin.Context) {
+	groupID := c.Param("id")
+
+	if _, err := authz.GroupRole(ic.DB, groupID, c.GetString("userID"), models.GroupRoleAdmin); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	var invites []models.GroupInvite
+	if err := ic.DB.
+		Where("group_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", groupID, time.Now()).
+		Where("max_uses = 0 OR uses < max_uses").
+		Order("created_at desc").
+		Find(&invites).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, invites)
+}
+
+// RevokeInvite (DELETE /api/groups/:id/invites/:inviteId) — admins and owner
+func (ic *InviteController) RevokeInvite(c *gin.Context) {
+	groupID := c.Param("id")
+
+	if _, err := authz.GroupRole(ic.DB, groupID, c.GetString("userID"), models.GroupRoleAdmin); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	res := ic.DB.Model(&models.GroupInvite{}).
+		Where("id = ? AND group_id = ? AND revoked_at IS NULL", c.Param("inviteId"), groupID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invite not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "invite revoked"})
+}
+
+// AcceptInvite (POST /api/invites/:token/join) — joins the invite's group,
+// whatever its visibility.
+func (ic *InviteController) AcceptInvite(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var groupID string
+	err := ic.DB.Transaction(func(tx *gorm.DB) error {
+		var invite models.GroupInvite
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&invite, "token = ?", c.Param("token")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInviteInvalid
+			}
+			return err
+		}
+		if invite.RevokedAt != nil ||
+			(invite.ExpiresAt != nil && invite.ExpiresAt.Before(time.Now())) ||
+			(invite.MaxUses > 0 && invite.Uses >= invite.MaxUses) {
+			return errInviteInvalid
+		}
+
+		var group models.ChatGroup
+		if err := tx.Select("id").First(&group, "id = ?", invite.GroupID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInviteInvalid
+			}
+			return err
+		}
+		groupID = group.ID
+
+		var count int64
+		tx.Model(&models.GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Count(&count)
+		if count > 0 {
+			return errAlreadyMember
+		}
+
+		if err := tx.Model(&invite).Update("uses", gorm.Expr("uses + 1")).Error; err != nil {
+			return err
+		}
+		return addGroupMember(tx, groupID, userID)
+	})
+	switch {
+	case errors.Is(err, errInviteInvalid):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errAlreadyMember):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	publishMembership(ic.DB, ic.Hub, groupID, "group.member_joined", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "joined group", "group_id": groupID})
+}
+
+// GetJoinRequests (GET /api/groups/:id/requests) — admins and owner, pending only
+func (ic *InviteController) GetJoinRequests(c *gin.Context) {
+	groupID := c.Param("id")
+
+	if _, err := authz.GroupRole(ic.DB, groupID, c.GetString("userID"), models.GroupRoleAdmin); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	var reqs []models.GroupJoinRequest
+	if err := ic.DB.Preload("User").
+		Where("group_id = ? AND status = ?", groupID, models.JoinRequestPending).
+		Order("created_at asc").
+		Find(&reqs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range reqs {
+		reqs[i].User.Password = ""
+	}
+	c.JSON(http.StatusOK, reqs)
+}
+
+// ApproveJoinRequest (POST /api/groups/:id/requests/:requestId/approve)
+func (ic *InviteController) ApproveJoinRequest(c *gin.Context) {
+	ic.decideJoinRequest(c, models.JoinRequestApproved)
+}
+
+// RejectJoinRequest (POST /api/groups/:id/requests/:requestId/reject)
+func (ic *InviteController) RejectJoinRequest(c *gin.Context) {
+	ic.decideJoinRequest(c, models.JoinRequestRejected)
+}
+
+func (ic *InviteController) decideJoinRequest(c *gin.Context, status string) {
+	groupID := c.Param("id")
+	userID := c.GetString("userID")
+
+	if _, err := authz.GroupRole(ic.DB, groupID, userID, models.GroupRoleAdmin); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	var req models.GroupJoinRequest
+	err := ic.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&req, "id = ? AND group_id = ? AND status = ?", c.Param("requestId"), groupID, models.JoinRequestPending).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := tx.Model(&req).Updates(map[string]interface{}{
+			"status":     status,
+			"decided_by": userID,
+			"decided_at": &now,
+		}).Error; err != nil {
+			return err
+		}
+		if status != models.JoinRequestApproved {
+			return nil
+		}
+		var count int64
+		tx.Model(&models.GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, req.UserID).Count(&count)
+		if count > 0 {
+			return nil
+		}
+		return addGroupMember(tx, groupID, req.UserID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "join request not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if status == models.JoinRequestApproved {
+		publishMembership(ic.DB, ic.Hub, groupID, "group.member_joined", req.UserID)
+	} else {
+		ic.Hub.Publish([]string{req.UserID}, realtime.Event{
+			Type: "group.join_rejected",
+			Data: gin.H{"group_id": groupID, "request_id": req.ID},
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "join request " + status})
+}
+
+// randomToken returns n random bytes, URL-safe base64 encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chat-app/authz"
+	"chat-app/models"
+)
+
+const (
+	// loginBackoffBase is the wait after the first failure; it doubles with
+	// every further one, up to loginBackoffMax, until the lockout kicks in.
+	loginBackoffBase = time.Second
+	loginBackoffMax  = time.Minute
+	// ipFailureFactor: an IP may fail this many times more often than a
+	// single email before it is locked out, since many users can share one
+	// address (NAT, office proxies).
+	ipFailureFactor = 10
+)
+
+// dummyPasswordHash is compared against when the email is unknown, so the
+// response takes as long as for a wrong password and doesn't reveal which
+// emails have accounts.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// loginLimits reads LOGIN_MAX_FAILURES (default 5) and
+// LOGIN_LOCKOUT_MINUTES (default 15).
+func loginLimits() (maxFailures int, lockout time.Duration) {
+	maxFailures, lockout = 5, 15*time.Minute
+	if v, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILURES")); err == nil && v > 0 {
+		maxFailures = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_MINUTES")); err == nil && v > 0 {
+		lockout = time.Duration(v) * time.Minute
+	}
+	return maxFailures, lockout
+}
+
+// loginBackoff is how long to wait after the n-th failure in a row.
+func loginBackoff(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	if n > 16 {
+		// the shift below would overflow long before this anyway
+		return loginBackoffMax
+	}
+	if d := loginBackoffBase << (n - 1); d < loginBackoffMax {
+		return d
+	}
+	return loginBackoffMax
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// errLoginThrottled rolls back a reservation that had to be refused.
+var errLoginThrottled = errors.New("login throttled")
+
+// reserveLoginAttempt counts a login attempt for email from the caller's IP
+// before the password or code is checked. The counters are locked while
+// they are checked and bumped, so parallel requests can't all slip past the
+// backoff or lockout: each one sees the attempts before it. The attempt is
+// taken back by loginSucceeded if the credentials turn out right. If the
+// email or IP has to wait it answers 429 with Retry-After and reports false.
+func (uc *UserController) reserveLoginAttempt(c *gin.Context, email string) bool {
+	var wait time.Duration
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		// always email before IP, so concurrent reservations can't deadlock
+		var err error
+		wait, err = reserveAttempt(tx, models.LoginScopeEmail, normalizeEmail(email), uc.MaxLoginFailures, uc.LoginLockout)
+		if err != nil {
+			return err
+		}
+		if wait <= 0 {
+			wait, err = reserveAttempt(tx, models.LoginScopeIP, c.ClientIP(), uc.MaxLoginFailures*ipFailureFactor, uc.LoginLockout)
+			if err != nil {
+				return err
+			}
+		}
+		if wait > 0 {
+			return errLoginThrottled
+		}
+		return nil
+	})
+	if errors.Is(err, errLoginThrottled) {
+		secs := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(secs))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       fmt.Sprintf("too many failed login attempts, try again in %d seconds", secs),
+			"retry_after": secs,
+		})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// reserveAttempt locks the counter of one email or IP and returns how long
+// it has to wait; if it doesn't, the attempt is counted (as a failure until
+// it succeeds) and the lockout set once the count reaches max.
+func reserveAttempt(tx *gorm.DB, scope, subject string, max int, lockout time.Duration) (time.Duration, error) {
+	now := time.Now()
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginFailure{
+		Scope: scope, Subject: subject, LastFailedAt: now,
+	}).Error; err != nil {
+		return 0, err
+	}
+
+	var row models.LoginFailure
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&row, "scope = ? AND subject = ?", scope, subject).Error; err != nil {
+		return 0, err
+	}
+	if now.Sub(row.LastFailedAt) > models.LoginFailureWindow {
+		row.Failures = 0
+	}
+
+	var until time.Time
+	if row.LockedUntil != nil {
+		until = *row.LockedUntil
+	}
+	// IPs are only locked out, not slowed down: one user's typos
+	// shouldn't delay everybody behind the same NAT
+	if scope == models.LoginScopeEmail {
+		if b := row.LastFailedAt.Add(loginBackoff(row.Failures)); b.After(until) {
+			until = b
+		}
+	}
+	if wait := until.Sub(now); wait > 0 {
+		return wait, nil
+	}
+
+	row.Failures++
+	row.LastFailedAt = now
+	if row.Failures >= max {
+		until := now.Add(lockout)
+		row.LockedUntil = &until
+	}
+	return 0, tx.Save(&row).Error
+}
+
+// loginSucceeded takes back the attempts counted for a correct password or
+// code. A complete login (complete) also forgets the email's earlier
+// failures; after the password step of a 2FA login they stay, so knowing
+// the password doesn't reset the limit on guessing codes. Only the IP's
+// current attempt is taken back: succeeding with one account mustn't make
+// it cheaper to guess at others.
+func (uc *UserController) loginSucceeded(c *gin.Context, email string, complete bool) error {
+	return uc.DB.Transaction(func(tx *gorm.DB) error {
+		if complete {
+			if err := tx.Where("scope = ? AND subject = ?", models.LoginScopeEmail, normalizeEmail(email)).
+				Delete(&models.LoginFailure{}).Error; err != nil {
+				return err
+			}
+		} else if err := releaseAttempt(tx, models.LoginScopeEmail, normalizeEmail(email), uc.MaxLoginFailures); err != nil {
+			return err
+		}
+		return releaseAttempt(tx, models.LoginScopeIP, c.ClientIP(), uc.MaxLoginFailures*ipFailureFactor)
+	})
+}
+
+// releaseAttempt undoes one reserveAttempt, including a lockout it set.
+func releaseAttempt(tx *gorm.DB, scope, subject string, max int) error {
+	var row models.LoginFailure
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&row, "scope = ? AND subject = ?", scope, subject).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if row.Failures > 0 {
+		row.Failures--
+	}
+	if row.Failures < max {
+		row.LockedUntil = nil
+	}
+	return tx.Save(&row).Error
+}
+
+// clearLoginFailures forgets the failures and lockout of email.
+func (uc *UserController) clearLoginFailures(email string) error {
+	return uc.DB.Where("scope = ? AND subject = ?", models.LoginScopeEmail, normalizeEmail(email)).
+		Delete(&models.LoginFailure{}).Error
+}
+
+// UnlockUser (POST /api/users/:id/unlock) — admins only. Clears the failed
+// login count and lockout of the account's email.
+func (uc *UserController) UnlockUser(c *gin.Context) {
+	if err := authz.Admin(uc.DB, c.GetString("userID")); err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := uc.clearLoginFailures(user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
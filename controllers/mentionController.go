@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chat-app/models"
+	"chat-app/realtime"
+)
+
+// mentionPattern matches @tokens that start a word: "@bob", "(@bob)", but not
+// the "@example" in "alice@example.com".
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.\-]+)`)
+
+type MentionController struct {
+	DB *gorm.DB
+}
+
+func NewMentionController(db *gorm.DB) *MentionController {
+	return &MentionController{DB: db}
+}
+
+// GetMentions (GET /api/mentions) — the caller's unread mentions across all
+// conversations, newest first. Mentions in groups the caller has left or
+// been removed from are left out. Paginate with ?limit= and
+// ?before=next_cursor.
+func (mc *MentionController) GetMentions(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	limit, before, _, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	q := mc.DB.Preload("Message").Preload("Message.Sender").
+		Joins("JOIN messages ON messages.id = mentions.message_id AND messages.deleted_at IS NULL").
+		Where("mentions.user_id = ? AND mentions.is_read = ?", userID, false).
+		Where("(messages.group_id IS NULL OR EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = messages.group_id AND gm.user_id = ? AND gm.deleted_at IS NULL))", userID)
+	if before != nil {
+		q = q.Where("mentions.created_at < ? OR (mentions.created_at = ? AND mentions.id < ?)", before.At, before.At, before.ID)
+	}
+
+	var mentions []models.Mention
+	if err := q.Order("mentions.created_at desc, mentions.id desc").Limit(limit + 1).Find(&mentions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var next *string
+	if len(mentions) > limit {
+		mentions = mentions[:limit]
+		last := mentions[len(mentions)-1]
+		cur := encodeCursor(last.CreatedAt, last.ID)
+		next = &cur
+	}
+	for i := range mentions {
+		mentions[i].Message.Sender.Password = ""
+	}
+	if mentions == nil {
+		mentions = []models.Mention{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mentions": mentions, "next_cursor": next})
+}
+
+// MarkMentionRead (POST /api/mentions/:id/read)
+func (mc *MentionController) MarkMentionRead(c *gin.Context) {
+	now := time.Now()
+	res := mc.DB.Model(&models.Mention{}).
+		Where("id = ? AND user_id = ?", c.Param("id"), c.GetString("userID")).
+		Updates(map[string]interface{}{"is_read": true, "read_at": &now})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "mention not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "marked as read"})
+}
+
+// parseMentions extracts the @usernames in content and whether it contains
+// @all or @here. Usernames are returned without the @ and de-duplicated.
+func parseMentions(content string) (usernames []string, all, here bool) {
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.TrimRight(m[1], ".-") // "@bob." at the end of a sentence
+		switch strings.ToLower(name) {
+		case "":
+			continue
+		case "all":
+			all = true
+			continue
+		case "here":
+			here = true
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			usernames = append(usernames, name)
+		}
+	}
+	return usernames, all, here
+}
+
+// recordMentions stores a Mention for every user msg mentions and returns
+// their IDs.
+func recordMentions(tx *gorm.DB, msg *models.Message) ([]string, error) {
+	targets, err := mentionTargets(tx, msg)
+	if err != nil {
+		return nil, err
+	}
+	var mentioned []string
+	for userID, kind := range targets {
+		if err := createMention(tx, msg, userID, kind); err != nil {
+			return nil, err
+		}
+		mentioned = append(mentioned, userID)
+	}
+	return mentioned, nil
+}
+
+// syncMentions brings the Mentions of an edited msg in line with its new
+// content: users no longer mentioned lose theirs, newly mentioned users get
+// one and are returned. Users mentioned before and after keep their
+// mention, read or not.
+func syncMentions(tx *gorm.DB, msg *models.Message) ([]string, error) {
+	targets, err := mentionTargets(tx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []string
+	if err := tx.Model(&models.Mention{}).Where("message_id = ?", msg.ID).Pluck("user_id", &existing).Error; err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, userID := range existing {
+		if _, ok := targets[userID]; ok {
+			delete(targets, userID)
+		} else {
+			removed = append(removed, userID)
+		}
+	}
+	if len(removed) > 0 {
+		if err := tx.Where("message_id = ? AND user_id IN ?", msg.ID, removed).Delete(&models.Mention{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var added []string
+	for userID, kind := range targets {
+		if err := createMention(tx, msg, userID, kind); err != nil {
+			return nil, err
+		}
+		added = append(added, userID)
+	}
+	return added, nil
+}
+
+// mentionTargets returns the users msg mentions, with the kind of each
+// mention. Only people who can see the message can be mentioned: group
+// members for a group message, the receiver for a 1‑on‑1 message. @all and
+// @here only apply to groups (@here = members who are online right now).
+// The sender is never mentioned.
+func mentionTargets(tx *gorm.DB, msg *models.Message) (map[string]string, error) {
+	usernames, all, here := parseMentions(msg.Content)
+	if len(usernames) == 0 && !all && !here {
+		return map[string]string{}, nil
+	}
+
+	// candidates: everyone who can see the message
+	q := tx.Model(&models.User{}).Select("users.id, users.username, users.is_online")
+	if msg.GroupID != nil {
+		q = q.Joins("JOIN group_members ON group_members.user_id = users.id AND group_members.deleted_at IS NULL").
+			Where("group_members.group_id = ?", *msg.GroupID)
+	} else if msg.ReceiverID != nil {
+		q = q.Where("users.id = ?", *msg.ReceiverID)
+		all, here = false, false
+	} else {
+		return nil, errors.New("message has no conversation")
+	}
+	var candidates []models.User
+	if err := q.Where("users.id <> ?", msg.SenderID).Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	named := make(map[string]bool, len(usernames))
+	for _, u := range usernames {
+		named[strings.ToLower(u)] = true
+	}
+
+	targets := make(map[string]string)
+	for _, u := range candidates {
+		switch {
+		case named[strings.ToLower(u.Username)]:
+			targets[u.ID] = models.MentionUser
+		case all:
+			targets[u.ID] = models.MentionAll
+		case here && u.IsOnline:
+			targets[u.ID] = models.MentionHere
+		}
+	}
+	return targets, nil
+}
+
+func createMention(tx *gorm.DB, msg *models.Message, userID, kind string) error {
+	return tx.Create(&models.Mention{
+		ID:        uuid.NewString(),
+		MessageID: msg.ID,
+		UserID:    userID,
+		Kind:      kind,
+	}).Error
+}
+
+// publishMentions notifies mentioned users right away, on top of the normal
+// message.created event, so clients can badge their mentions inbox.
+func publishMentions(hub *realtime.Hub, msg *models.Message, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	hub.Publish(userIDs, realtime.Event{
+		Type: "mention.created",
+		Data: gin.H{"message_id": msg.ID, "group_id": msg.GroupID, "sender_id": msg.SenderID},
+	})
+}
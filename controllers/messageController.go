@@ -1,34 +1,73 @@
 package controllers
 
 import (
+    "context"
     "errors"
+    "log"
     "net/http"
+    "os"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/gin-gonic/gin"
     "github.com/google/uuid"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
 
+    "chat-app/authz"
     "chat-app/models"
+    "chat-app/realtime"
+    "chat-app/search"
 )
 
+// maxIdempotencyKeyLength matches the column size of IdempotencyKey.Key.
+const maxIdempotencyKeyLength = 100
+
 type MessageController struct {
-    DB *gorm.DB
+    DB                *gorm.DB
+    Hub               *realtime.Hub
+    Search            search.Index
+    EditWindow        time.Duration // 0 = messages can always be edited
+    IdempotencyWindow time.Duration // how long a retried send returns the original message
+    RequireVerified   bool          // only users with a verified email may send
 }
 
-func NewMessageController(db *gorm.DB) *MessageController {
-    return &MessageController{DB: db}
+func NewMessageController(db *gorm.DB, hub *realtime.Hub, idx search.Index) *MessageController {
+    window := 15 * time.Minute
+    if v := os.Getenv("MESSAGE_EDIT_WINDOW_MINUTES"); v != "" {
+        if mins, err := strconv.Atoi(v); err == nil && mins >= 0 {
+            window = time.Duration(mins) * time.Minute
+        }
+    }
+    idempotency := 24 * time.Hour
+    if v := os.Getenv("IDEMPOTENCY_WINDOW_HOURS"); v != "" {
+        if hours, err := strconv.Atoi(v); err == nil && hours > 0 {
+            idempotency = time.Duration(hours) * time.Hour
+        }
+    }
+    return &MessageController{
+        DB: db, Hub: hub, Search: idx, EditWindow: window, IdempotencyWindow: idempotency,
+        RequireVerified: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+    }
 }
 
 type sendMsgInput struct {
-    Content    string  `json:"content" binding:"required"`
-    GroupID    *string `json:"group_id"`    // optional
-    ReceiverID *string `json:"receiver_id"` // optional
+    Content       string   `json:"content"`        // required unless attachment_ids is set
+    GroupID       *string  `json:"group_id"`       // optional
+    ReceiverID    *string  `json:"receiver_id"`    // optional
+    ParentID      *string  `json:"parent_id"`      // optional: reply to this message (same conversation)
+    AttachmentIDs []string `json:"attachment_ids"` // optional: uploaded via POST /api/attachments
+    // optional: client-generated ID; retries with the same ID (or the same
+    // Idempotency-Key header) return the original message_id
+    ClientMessageID string `json:"client_message_id"`
 }
 
 
 // SendMessage (POST /api/messages)
 // Supports: 1) pesan ke grup (isi group_id) 2) pesan 1‑on‑1 (isi receiver_id)
+// A retry carrying the same client_message_id / Idempotency-Key within the
+// idempotency window gets 200 with the original message_id.
 func (mc *MessageController) SendMessage(c *gin.Context) {
     var input sendMsgInput
     if err := c.ShouldBindJSON(&input); err != nil {
@@ -36,13 +75,54 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
         return
     }
 
-    if input.GroupID == nil && input.ReceiverID == nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "either group_id or receiver_id required"})
+    key := c.GetHeader("Idempotency-Key")
+    if input.ClientMessageID != "" {
+        if key != "" && key != input.ClientMessageID {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key and client_message_id differ"})
+            return
+        }
+        key = input.ClientMessageID
+    }
+    if len(key) > maxIdempotencyKeyLength {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "idempotency key too long"})
+        return
+    }
+    if key != "" {
+        if id, ok := mc.sentWithKey(c.GetString("userID"), key); ok {
+            replaySend(c, id)
+            return
+        }
+    }
+
+    if mc.RequireVerified {
+        verified, err := emailVerified(mc.DB, c.GetString("userID"))
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if !verified {
+            c.JSON(http.StatusForbidden, gin.H{"error": errEmailUnverified.Error()})
+            return
+        }
+    }
+
+    input.AttachmentIDs = uniqueStrings(input.AttachmentIDs)
+    if strings.TrimSpace(input.Content) == "" && len(input.AttachmentIDs) == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "content or attachment_ids required"})
+        return
+    }
+    if len(input.AttachmentIDs) > maxAttachmentsPerMessage {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "too many attachments"})
         return
     }
 
     senderID, _ := c.Get("userID")
 
+    if err := authz.Conversation(mc.DB, senderID.(string), input.GroupID, input.ReceiverID); err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+
     msg := models.Message{
         ID:         uuid.NewString(),
         SenderID:   senderID.(string),
@@ -51,6 +131,24 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
         Content:    input.Content,
     }
 
+    if input.ParentID != nil {
+        parent, err := authz.Message(mc.DB, *input.ParentID, senderID.(string))
+        if err != nil {
+            abortWithAuthzError(c, err)
+            return
+        }
+        if !sameConversation(parent, &msg) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "parent message is in another conversation"})
+            return
+        }
+        root := parent.ID
+        if parent.ThreadRootID != nil {
+            root = *parent.ThreadRootID
+        }
+        msg.ParentID = &parent.ID
+        msg.ThreadRootID = &root
+    }
+
     tx := mc.DB.Begin()
     if err := tx.Create(&msg).Error; err != nil {
         tx.Rollback()
@@ -58,7 +156,57 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
         return
     }
 
-    // create MessageStatus entries for recipients (unread)
+    if key != "" {
+        // the primary key makes a concurrent retry wait for us here and then
+        // find the row taken, so only one of them sends
+        tx.Where("sender_id = ? AND `key` = ? AND expires_at <= ?", msg.SenderID, key, time.Now()).
+            Delete(&models.IdempotencyKey{})
+        res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.IdempotencyKey{
+            SenderID:  msg.SenderID,
+            Key:       key,
+            MessageID: msg.ID,
+            ExpiresAt: time.Now().Add(mc.IdempotencyWindow),
+        })
+        if res.Error != nil {
+            tx.Rollback()
+            c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+            return
+        }
+        if res.RowsAffected == 0 {
+            tx.Rollback()
+            if id, ok := mc.sentWithKey(msg.SenderID, key); ok {
+                replaySend(c, id)
+                return
+            }
+            c.JSON(http.StatusConflict, gin.H{"error": "a message with this idempotency key is being sent"})
+            return
+        }
+    }
+
+    if err := linkAttachments(tx, &msg, input.AttachmentIDs); err != nil {
+        tx.Rollback()
+        if errors.Is(err, errInvalidAttachment) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    if msg.ThreadRootID != nil {
+        if err := tx.Model(&models.Message{}).Where("id = ?", *msg.ThreadRootID).
+            Updates(map[string]interface{}{
+                "reply_count":   gorm.Expr("reply_count + 1"),
+                "last_reply_at": msg.SentAt,
+            }).Error; err != nil {
+            tx.Rollback()
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+    }
+
+    // everyone else in the conversation gets the message.created event;
+    // unread state comes from their read watermarks
     var recipients []string
 
     if input.GroupID != nil {
@@ -77,34 +225,74 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
         recipients = append(recipients, *input.ReceiverID)
     }
 
-    for _, rid := range recipients {
-        tx.Create(&models.MessageStatus{
-            MessageID: msg.ID,
-            UserID:    rid,
-            IsRead:    false,
-        })
+    mentioned, err := recordMentions(tx, &msg)
+    if err != nil {
+        tx.Rollback()
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := tx.Commit().Error; err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
     }
 
-    tx.Commit()
+    // push to everyone in the conversation, including the sender's other devices
+    mc.DB.First(&msg.Sender, "id = ?", msg.SenderID)
+    msg.Sender.Password = ""
+    mc.Hub.Publish(append(recipients, msg.SenderID), realtime.Event{Type: "message.created", Data: msg})
+    publishMentions(mc.Hub, &msg, mentioned)
+    mc.index(&msg)
 
     c.JSON(http.StatusCreated, gin.H{"message_id": msg.ID})
 }
 
+// sentWithKey returns the message an unexpired idempotency key created.
+func (mc *MessageController) sentWithKey(senderID, key string) (string, bool) {
+    var k models.IdempotencyKey
+    if err := mc.DB.Where("sender_id = ? AND `key` = ? AND expires_at > ?", senderID, key, time.Now()).
+        First(&k).Error; err != nil {
+        return "", false
+    }
+    return k.MessageID, true
+}
+
+// replaySend answers a retried SendMessage with the original message.
+func replaySend(c *gin.Context, msgID string) {
+    c.Header("Idempotent-Replayed", "true")
+    c.JSON(http.StatusOK, gin.H{"message_id": msgID})
+}
+
 // GetMessages (GET /api/messages)
-// query params: group_id OR receiver_id (one‑on‑one). Order by SentAt asc.
+// query params: group_id OR receiver_id (one‑on‑one), plus limit and a
+// before/after cursor. A page is always returned oldest first; without a
+// cursor it is the most recent page.
 func (mc *MessageController) GetMessages(c *gin.Context) {
     groupID := c.Query("group_id")
     recvID := c.Query("receiver_id")
     userID, _ := c.Get("userID")
 
-    var msgs []models.Message
+    limit, before, after, err := pageParams(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
 
-    q := mc.DB.Preload("Sender").Order("sent_at asc")
+    // replies are fetched per thread, see GetReplies
+    q := mc.DB.Preload("Sender").Preload("Attachments.Thumbnails").Where("thread_root_id IS NULL")
 
     switch {
     case groupID != "":
+        if err := authz.Group(mc.DB, groupID, userID.(string)); err != nil {
+            abortWithAuthzError(c, err)
+            return
+        }
         q = q.Where("group_id = ?", groupID)
     case recvID != "":
+        if err := authz.Direct(mc.DB, recvID); err != nil {
+            abortWithAuthzError(c, err)
+            return
+        }
         // ambil chat 1‑on‑1 (pesan yg saya kirim atau terima)
         q = q.Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)", userID, recvID, recvID, userID)
     default:
@@ -112,38 +300,400 @@ func (mc *MessageController) GetMessages(c *gin.Context) {
         return
     }
 
-    if err := q.Find(&msgs).Error; err != nil {
+    page, err := fetchMessagePage(q, limit, before, after)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := mc.fillUnreadReplies(userID.(string), page.Messages); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if err := fillReactions(mc.DB, userID.(string), page.Messages); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if err := fillReceipts(mc.DB, userID.(string), page.Messages); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
 
     // hide password inside Sender
+    for i := range page.Messages {
+        page.Messages[i].Sender.Password = ""
+    }
+
+    deliverPage(mc.DB, mc.Hub, userID.(string), page.Messages)
+    c.JSON(http.StatusOK, page)
+}
+
+// GetReplies (GET /api/messages/:id/replies) — replies in the thread rooted at
+// :id, oldest first, paginated like GetMessages.
+func (mc *MessageController) GetReplies(c *gin.Context) {
+    userID := c.GetString("userID")
+
+    root, err := authz.Message(mc.DB, c.Param("id"), userID)
+    if err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+    if root.ThreadRootID != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "message is a reply, use its thread root"})
+        return
+    }
+
+    limit, before, after, err := pageParams(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    page, err := fetchMessagePage(mc.DB.Preload("Sender").Preload("Attachments.Thumbnails").Where("thread_root_id = ?", root.ID), limit, before, after)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if err := fillReactions(mc.DB, userID, page.Messages); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if err := fillReceipts(mc.DB, userID, page.Messages); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    for i := range page.Messages {
+        page.Messages[i].Sender.Password = ""
+    }
+    deliverPage(mc.DB, mc.Hub, userID, page.Messages)
+    c.JSON(http.StatusOK, page)
+}
+
+// MarkThreadRead (POST /api/messages/:id/thread/read) — marks every reply in
+// the thread rooted at :id as read for the current user.
+func (mc *MessageController) MarkThreadRead(c *gin.Context) {
+    userID := c.GetString("userID")
+
+    root, err := authz.Message(mc.DB, c.Param("id"), userID)
+    if err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+
+    var latest []models.Message
+    if err := mc.DB.Where("thread_root_id = ?", root.ID).Order("sent_at desc, id desc").Limit(1).Find(&latest).Error; err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if len(latest) == 1 {
+        if _, err := markReadUpTo(mc.DB, userID, &latest[0]); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "thread marked as read"})
+}
+
+// fillUnreadReplies sets UnreadReplies on every thread root in msgs.
+func (mc *MessageController) fillUnreadReplies(userID string, msgs []models.Message) error {
+    var roots []string
+    for _, m := range msgs {
+        if m.ReplyCount > 0 {
+            roots = append(roots, m.ID)
+        }
+    }
+    if len(roots) == 0 {
+        return nil
+    }
+
+    var rows []struct {
+        ThreadRootID string
+        Unread       int64
+    }
+    if err := unreadMessages(mc.DB, userID, threadKeyExpr).
+        Select("messages.thread_root_id, COUNT(*) AS unread").
+        Where("messages.thread_root_id IN ?", roots).
+        Group("messages.thread_root_id").
+        Scan(&rows).Error; err != nil {
+        return err
+    }
+
+    unread := make(map[string]int64, len(rows))
+    for _, r := range rows {
+        unread[r.ThreadRootID] = r.Unread
+    }
     for i := range msgs {
-        msgs[i].Sender.Password = ""
+        msgs[i].UnreadReplies = unread[msgs[i].ID]
+    }
+    return nil
+}
+
+// sameConversation reports whether msg belongs to the same group or 1‑on‑1
+// conversation as parent.
+func sameConversation(parent, msg *models.Message) bool {
+    if parent.GroupID != nil || msg.GroupID != nil {
+        return parent.GroupID != nil && msg.GroupID != nil && *parent.GroupID == *msg.GroupID
+    }
+    if parent.ReceiverID == nil || msg.ReceiverID == nil {
+        return false
+    }
+    return (parent.SenderID == msg.SenderID && *parent.ReceiverID == *msg.ReceiverID) ||
+        (parent.SenderID == *msg.ReceiverID && *parent.ReceiverID == msg.SenderID)
+}
+
+// messagePage is the response envelope for paginated message lists.
+// PrevCursor fetches older messages (?before=), NextCursor newer ones
+// (?after=); each is null when there is nothing more in that direction.
+type messagePage struct {
+    Messages   []models.Message `json:"messages"`
+    PrevCursor *string          `json:"prev_cursor"`
+    NextCursor *string          `json:"next_cursor"`
+}
+
+// fetchMessagePage runs q (already filtered to one conversation) for a single
+// page ordered by (sent_at, id).
+func fetchMessagePage(q *gorm.DB, limit int, before, after *cursor) (*messagePage, error) {
+    var msgs []models.Message
+
+    if after != nil {
+        q = q.Where("sent_at > ? OR (sent_at = ? AND id > ?)", after.At, after.At, after.ID).
+            Order("sent_at asc, id asc")
+    } else {
+        if before != nil {
+            q = q.Where("sent_at < ? OR (sent_at = ? AND id < ?)", before.At, before.At, before.ID)
+        }
+        q = q.Order("sent_at desc, id desc")
+    }
+
+    // fetch one extra row to know if there is more in this direction
+    if err := q.Limit(limit + 1).Find(&msgs).Error; err != nil {
+        return nil, err
+    }
+    hasMore := len(msgs) > limit
+    if hasMore {
+        msgs = msgs[:limit]
+    }
+    if after == nil {
+        for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+            msgs[i], msgs[j] = msgs[j], msgs[i]
+        }
+    }
+
+    page := &messagePage{Messages: msgs}
+    if page.Messages == nil {
+        page.Messages = []models.Message{}
+    }
+    if len(msgs) == 0 {
+        return page, nil
     }
 
-    c.JSON(http.StatusOK, msgs)
+    first, last := msgs[0], msgs[len(msgs)-1]
+    if (after == nil && hasMore) || after != nil {
+        prev := encodeCursor(first.SentAt, first.ID)
+        page.PrevCursor = &prev
+    }
+    if (after != nil && hasMore) || before != nil {
+        next := encodeCursor(last.SentAt, last.ID)
+        page.NextCursor = &next
+    }
+    return page, nil
 }
 
-// MarkRead (POST /api/messages/:id/read) — current user marks msg read
+// MarkRead (POST /api/messages/:id/read) — current user has read msg and
+// everything before it in the same conversation (or thread).
 func (mc *MessageController) MarkRead(c *gin.Context) {
+    userID := c.GetString("userID")
+
+    msg, err := authz.Message(mc.DB, c.Param("id"), userID)
+    if err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+
+    advanced, err := markReadUpTo(mc.DB, userID, msg)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if advanced {
+        publishReceipt(mc.DB, mc.Hub, "message.read", userID, msg)
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "marked as read"})
+}
+
+// MarkDelivered (POST /api/messages/:id/delivered) — acknowledges that the
+// caller's client received msg (and everything before it) through a live
+// push. Websocket clients can send {"type": "delivered"} frames instead.
+func (mc *MessageController) MarkDelivered(c *gin.Context) {
+    if err := ackDelivered(mc.DB, mc.Hub, c.GetString("userID"), c.Param("id")); err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "marked as delivered"})
+}
+
+// GetReceipts (GET /api/messages/:id/receipts) — sender only: the
+// delivered / read state of the message for each recipient, plus totals
+// ("read by N of M").
+func (mc *MessageController) GetReceipts(c *gin.Context) {
+    userID := c.GetString("userID")
+
+    msg, err := authz.Message(mc.DB, c.Param("id"), userID)
+    if err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+    if msg.SenderID != userID {
+        c.JSON(http.StatusForbidden, gin.H{"error": "only sender can view receipts"})
+        return
+    }
+
+    rows, err := receiptRows(mc.DB, []string{msg.ID})
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    ids := make([]string, len(rows))
+    for i, r := range rows {
+        ids[i] = r.UserID
+    }
+    names := make(map[string]string, len(ids))
+    if len(ids) > 0 {
+        var users []models.User
+        if err := mc.DB.Select("id, username").Where("id IN ?", ids).Find(&users).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        for _, u := range users {
+            names[u.ID] = u.Username
+        }
+    }
+
+    type recipient struct {
+        UserID   string `json:"user_id"`
+        Username string `json:"username"`
+        Status   string `json:"status"` // sent, delivered or read
+    }
+    summary := models.ReceiptSummary{Recipients: int64(len(rows))}
+    recipients := make([]recipient, len(rows))
+    for i, r := range rows {
+        status := models.ReceiptSent
+        if r.IsDelivered {
+            status = models.ReceiptDelivered
+            summary.Delivered++
+        }
+        if r.IsRead {
+            status = models.ReceiptRead
+            summary.Read++
+        }
+        recipients[i] = recipient{UserID: r.UserID, Username: names[r.UserID], Status: status}
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message_id": msg.ID, "recipients": recipients, "summary": summary})
+}
+
+// EditMessage (PATCH /api/messages/:id) — only the sender, while still in
+// the conversation and within the edit window. The previous content is kept as a revision.
+func (mc *MessageController) EditMessage(c *gin.Context) {
     msgID := c.Param("id")
-    userID, _ := c.Get("userID")
+    userID := c.GetString("userID")
+
+    var input struct {
+        Content string `json:"content" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    // a sender who has left the group can't edit into it any more
+    found, err := authz.Message(mc.DB, msgID, userID)
+    if err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+    msg := *found
+    if msg.SenderID != userID {
+        c.JSON(http.StatusForbidden, gin.H{"error": "only sender can edit message"})
+        return
+    }
+    if mc.EditWindow > 0 && time.Since(msg.SentAt) > mc.EditWindow {
+        c.JSON(http.StatusForbidden, gin.H{"error": "edit window has passed"})
+        return
+    }
+    if input.Content == msg.Content {
+        c.JSON(http.StatusOK, msg)
+        return
+    }
 
     now := time.Now()
-    res := mc.DB.Model(&models.MessageStatus{}).
-        Where("message_id = ? AND user_id = ?", msgID, userID).
-        Updates(map[string]interface{}{"is_read": true, "read_at": &now})
+    var mentioned []string
+    err = mc.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&models.MessageRevision{
+            ID:        uuid.NewString(),
+            MessageID: msg.ID,
+            Content:   msg.Content,
+            EditedBy:  userID,
+        }).Error; err != nil {
+            return err
+        }
+        if err := tx.Model(&msg).Updates(map[string]interface{}{"content": input.Content, "edited_at": &now}).Error; err != nil {
+            return err
+        }
+        msg.Content = input.Content
+        msg.EditedAt = &now
+        var err error
+        mentioned, err = syncMentions(tx, &msg)
+        return err
+    })
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    mc.DB.Where("message_id = ?", msg.ID).Find(&msg.Attachments)
+    mc.index(&msg)
 
-    if res.Error != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+    if ids, err := participantIDs(mc.DB, &msg); err == nil {
+        mc.Hub.Publish(ids, realtime.Event{
+            Type: "message.edited",
+            Data: gin.H{"message_id": msg.ID, "group_id": msg.GroupID, "receiver_id": msg.ReceiverID,
+                "content": msg.Content, "edited_at": msg.EditedAt},
+        })
+    }
+    publishMentions(mc.Hub, &msg, mentioned)
+
+    c.JSON(http.StatusOK, msg)
+}
+
+// GetRevisions (GET /api/messages/:id/revisions) — edit history, newest
+// first. Group admins (and the sender) may look at it.
+func (mc *MessageController) GetRevisions(c *gin.Context) {
+    userID := c.GetString("userID")
+
+    msg, err := authz.Message(mc.DB, c.Param("id"), userID)
+    if err != nil {
+        abortWithAuthzError(c, err)
         return
     }
-    if res.RowsAffected == 0 {
-        c.JSON(http.StatusNotFound, gin.H{"error": "status not found"})
+    if msg.SenderID != userID {
+        if msg.GroupID == nil {
+            c.JSON(http.StatusForbidden, gin.H{"error": "only sender can view revisions"})
+            return
+        }
+        if _, err := authz.GroupRole(mc.DB, *msg.GroupID, userID, models.GroupRoleAdmin); err != nil {
+            abortWithAuthzError(c, err)
+            return
+        }
+    }
+
+    var revs []models.MessageRevision
+    if err := mc.DB.Where("message_id = ?", msg.ID).Order("edited_at desc").Find(&revs).Error; err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
-    c.JSON(http.StatusOK, gin.H{"message": "marked as read"})
+    c.JSON(http.StatusOK, revs)
 }
 
 // DeleteMessage (DELETE /api/messages/:id) — only sender can soft‑delete
@@ -165,10 +715,64 @@ func (mc *MessageController) DeleteMessage(c *gin.Context) {
         return
     }
 
-    if err := mc.DB.Delete(&msg).Error; err != nil {
+    err := mc.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Delete(&msg).Error; err != nil {
+            return err
+        }
+        if msg.ThreadRootID == nil {
+            return nil
+        }
+        return tx.Model(&models.Message{}).Where("id = ? AND reply_count > 0", *msg.ThreadRootID).
+            Update("reply_count", gorm.Expr("reply_count - 1")).Error
+    })
+    if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    if err := mc.Search.Remove(context.Background(), msg.ID); err != nil {
+        log.Printf("search: remove %s: %v", msg.ID, err)
+    }
+
+    if ids, err := participantIDs(mc.DB, &msg); err == nil {
+        mc.Hub.Publish(ids, realtime.Event{
+            Type: "message.deleted",
+            Data: gin.H{"message_id": msg.ID, "group_id": msg.GroupID, "receiver_id": msg.ReceiverID},
+        })
+    }
 
     c.JSON(http.StatusOK, gin.H{"message": "message deleted"})
 }
+
+// index updates the search index after msg was sent or edited. The index
+// can be rebuilt from the database, so a failure only gets logged.
+func (mc *MessageController) index(msg *models.Message) {
+    if err := mc.Search.Index(context.Background(), search.DocumentFromMessage(msg)); err != nil {
+        log.Printf("search: index %s: %v", msg.ID, err)
+    }
+}
+
+// participantIDs returns every user who can see msg: the group members for a
+// group message, or sender and receiver for a 1‑on‑1 message.
+func participantIDs(db *gorm.DB, msg *models.Message) ([]string, error) {
+    if msg.GroupID != nil {
+        return groupMemberIDs(db, *msg.GroupID)
+    }
+    ids := []string{msg.SenderID}
+    if msg.ReceiverID != nil {
+        ids = append(ids, *msg.ReceiverID)
+    }
+    return ids, nil
+}
+
+// uniqueStrings drops duplicates from ss, keeping the first occurrence.
+func uniqueStrings(ss []string) []string {
+    seen := make(map[string]bool, len(ss))
+    out := ss[:0]
+    for _, s := range ss {
+        if !seen[s] {
+            seen[s] = true
+            out = append(out, s)
+        }
+    }
+    return out
+}
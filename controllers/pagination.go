@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// cursor points at a row ordered by (time, id). The pair is unique, so
+// paging stays stable even when several rows share a timestamp.
+type cursor struct {
+	At time.Time
+	ID string
+}
+
+func encodeCursor(at time.Time, id string) string {
+	raw := strconv.FormatInt(at.UnixNano(), 10) + ":" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cursor{At: time.Unix(0, nanos), ID: parts[1]}, nil
+}
+
+// pageParams reads ?limit=, ?before= and ?after= from the request. At most
+// one of before/after may be set. limit defaults to defaultPageSize and is
+// capped at maxPageSize.
+func pageParams(c *gin.Context) (limit int, before, after *cursor, err error) {
+	if limit, err = limitParam(c); err != nil {
+		return 0, nil, nil, err
+	}
+
+	if v := c.Query("before"); v != "" {
+		if before, err = decodeCursor(v); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	if v := c.Query("after"); v != "" {
+		if after, err = decodeCursor(v); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	if before != nil && after != nil {
+		return 0, nil, nil, errors.New("before and after are mutually exclusive")
+	}
+	return limit, before, after, nil
+}
+
+// limitParam reads ?limit=, defaulting to defaultPageSize and capped at
+// maxPageSize.
+func limitParam(c *gin.Context) (int, error) {
+	v := c.Query("limit")
+	if v == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
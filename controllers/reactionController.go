@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chat-app/authz"
+	"chat-app/models"
+	"chat-app/realtime"
+)
+
+// maxDistinctReactions caps how many different emojis a single message can
+// collect, so a message can't be turned into an emoji wall.
+const maxDistinctReactions = 20
+
+var errTooManyReactions = errors.New("this message already has the maximum number of different reactions")
+
+type ReactionController struct {
+	DB  *gorm.DB
+	Hub *realtime.Hub
+}
+
+func NewReactionController(db *gorm.DB, hub *realtime.Hub) *ReactionController {
+	return &ReactionController{DB: db, Hub: hub}
+}
+
+// AddReaction (POST /api/messages/:id/reactions) — body: {"emoji": "👍"}
+func (rc *ReactionController) AddReaction(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var input struct {
+		Emoji string `json:"emoji" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validEmoji(input.Emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid emoji"})
+		return
+	}
+
+	msg, err := authz.Message(rc.DB, c.Param("id"), userID)
+	if err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	var added bool
+	err = rc.DB.Transaction(func(tx *gorm.DB) error {
+		// lock the message so concurrent reactions can't overshoot the limit
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Message{}, "id = ?", msg.ID).Error; err != nil {
+			return err
+		}
+
+		var exists int64
+		if err := tx.Model(&models.MessageReaction{}).Where("message_id = ? AND emoji = ?", msg.ID, input.Emoji).
+			Count(&exists).Error; err != nil {
+			return err
+		}
+		if exists == 0 {
+			var distinct int64
+			if err := tx.Model(&models.MessageReaction{}).Where("message_id = ?", msg.ID).
+				Distinct("emoji").Count(&distinct).Error; err != nil {
+				return err
+			}
+			if distinct >= maxDistinctReactions {
+				return errTooManyReactions
+			}
+		}
+
+		// reacting twice with the same emoji is a no-op
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.MessageReaction{
+			MessageID: msg.ID,
+			UserID:    userID,
+			Emoji:     input.Emoji,
+		})
+		added = res.RowsAffected > 0
+		return res.Error
+	})
+	if errors.Is(err, errTooManyReactions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if added {
+		rc.publish(msg, "reaction.added", userID, input.Emoji)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "reaction added"})
+}
+
+// RemoveReaction (DELETE /api/messages/:id/reactions/:emoji)
+func (rc *ReactionController) RemoveReaction(c *gin.Context) {
+	userID := c.GetString("userID")
+	emoji := c.Param("emoji")
+
+	msg, err := authz.Message(rc.DB, c.Param("id"), userID)
+	if err != nil {
+		abortWithAuthzError(c, err)
+		return
+	}
+
+	res := rc.DB.Where("message_id = ? AND user_id = ? AND emoji = ?", msg.ID, userID, emoji).
+		Delete(&models.MessageReaction{})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "reaction not found"})
+		return
+	}
+
+	rc.publish(msg, "reaction.removed", userID, emoji)
+	c.JSON(http.StatusOK, gin.H{"message": "reaction removed"})
+}
+
+func (rc *ReactionController) publish(msg *models.Message, eventType, userID, emoji string) {
+	ids, err := participantIDs(rc.DB, msg)
+	if err != nil {
+		return
+	}
+	rc.Hub.Publish(ids, realtime.Event{
+		Type: eventType,
+		Data: gin.H{"message_id": msg.ID, "user_id": userID, "emoji": emoji},
+	})
+}
+
+// fillReactions sets the aggregated Reactions of every message in msgs, as
+// seen by userID.
+func fillReactions(db *gorm.DB, userID string, msgs []models.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(msgs))
+	for i := range msgs {
+		ids[i] = msgs[i].ID
+	}
+
+	var rows []struct {
+		MessageID string
+		Emoji     string
+		Count     int64
+		Reacted   bool
+	}
+	if err := db.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, MAX(CASE WHEN user_id = ? THEN 1 ELSE 0 END) AS reacted", userID).
+		Where("message_id IN ?", ids).
+		Group("message_id, emoji").
+		Order("MIN(created_at)").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	byMsg := make(map[string][]models.ReactionSummary)
+	for _, r := range rows {
+		byMsg[r.MessageID] = append(byMsg[r.MessageID], models.ReactionSummary{
+			Emoji:   r.Emoji,
+			Count:   r.Count,
+			Reacted: r.Reacted,
+		})
+	}
+	for i := range msgs {
+		msgs[i].Reactions = byMsg[msgs[i].ID]
+		if msgs[i].Reactions == nil {
+			msgs[i].Reactions = []models.ReactionSummary{}
+		}
+	}
+	return nil
+}
+
+// validEmoji accepts a short, single "word" of text: one emoji, possibly
+// made of several code points (skin tones, ZWJ sequences, flags).
+func validEmoji(s string) bool {
+	if s == "" || len(s) > 32 || !utf8.ValidString(s) {
+		return false
+	}
+	if strings.ContainsAny(s, " \t\r\n/") {
+		return false
+	}
+	return utf8.RuneCountInString(s) <= 10
+}
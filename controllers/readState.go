@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chat-app/authz"
+	"chat-app/models"
+	"chat-app/realtime"
+)
+
+// SQL expressions for the watermark key of the conversation a message row
+// belongs to, from the point of view of a user who received it.
+const (
+	groupKeyExpr  = "CONCAT('group:', messages.group_id)"
+	directKeyExpr = "CONCAT('dm:', messages.sender_id)"
+	threadKeyExpr = "CONCAT('thread:', messages.thread_root_id)"
+)
+
+// unreadMessages selects the messages userID hasn't read yet, joining their
+// watermark for the conversation identified by keyExpr. Without a
+// watermark, group messages since the user joined and all 1‑on‑1 messages
+// are unread. A user's own messages are never unread.
+func unreadMessages(db *gorm.DB, userID, keyExpr string) *gorm.DB {
+	return db.Table("messages").
+		Joins("LEFT JOIN read_watermarks wm ON wm.user_id = ? AND wm.conversation_key = "+keyExpr, userID).
+		Joins("LEFT JOIN group_members gm ON gm.group_id = messages.group_id AND gm.user_id = ? AND gm.deleted_at IS NULL", userID).
+		Where("messages.deleted_at IS NULL AND messages.sender_id <> ?", userID).
+		Where(`((wm.user_id IS NOT NULL AND (messages.sent_at > wm.last_read_at OR (messages.sent_at = wm.last_read_at AND messages.id > wm.last_read_message_id)))
+			OR (wm.user_id IS NULL AND (gm.joined_at IS NULL OR messages.sent_at >= gm.joined_at)))`)
+}
+
+// inConversation restricts q (on messages) to the conversation or thread msg
+// belongs to, as seen by userID.
+func inConversation(q *gorm.DB, userID string, msg *models.Message) *gorm.DB {
+	if msg.ThreadRootID != nil {
+		return q.Where("messages.thread_root_id = ?", *msg.ThreadRootID)
+	}
+	q = q.Where("messages.thread_root_id IS NULL")
+	if msg.GroupID != nil {
+		return q.Where("messages.group_id = ?", *msg.GroupID)
+	}
+	other := msg.SenderID
+	if other == userID && msg.ReceiverID != nil {
+		other = *msg.ReceiverID
+	}
+	return q.Where("messages.group_id IS NULL AND ((messages.sender_id = ? AND messages.receiver_id = ?) OR (messages.sender_id = ? AND messages.receiver_id = ?))",
+		userID, other, other, userID)
+}
+
+// markReadUpTo moves userID's read (and delivery) watermark in msg's
+// conversation forward to msg and clears their mentions up to it.
+// Watermarks never move backwards; advanced is false when msg was already
+// read.
+func markReadUpTo(db *gorm.DB, userID string, msg *models.Message) (advanced bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		key := models.ConversationKey(msg, userID)
+		read := &models.ReadWatermark{
+			UserID:            userID,
+			ConversationKey:   key,
+			LastReadMessageID: msg.ID,
+			LastReadAt:        msg.SentAt,
+		}
+		if advanced, err = advanceWatermark(tx, read, "last_read_message_id", "last_read_at", msg); err != nil || !advanced {
+			return err
+		}
+		if _, err := markDeliveredUpTo(tx, userID, msg); err != nil {
+			return err
+		}
+
+		upTo := inConversation(tx.Model(&models.Message{}).Select("messages.id"), userID, msg).
+			Where("messages.sent_at <= ?", msg.SentAt)
+		now := time.Now()
+		return tx.Model(&models.Mention{}).
+			Where("user_id = ? AND is_read = ? AND message_id IN (?)", userID, false, upTo).
+			Updates(map[string]interface{}{"is_read": true, "read_at": &now}).Error
+	})
+	return advanced, err
+}
+
+// markDeliveredUpTo moves userID's delivery watermark in msg's conversation
+// forward to msg. advanced is false when msg had already been delivered.
+func markDeliveredUpTo(db *gorm.DB, userID string, msg *models.Message) (advanced bool, err error) {
+	delivered := &models.DeliveryWatermark{
+		UserID:                 userID,
+		ConversationKey:        models.ConversationKey(msg, userID),
+		LastDeliveredMessageID: msg.ID,
+		LastDeliveredAt:        msg.SentAt,
+	}
+	return advanceWatermark(db, delivered, "last_delivered_message_id", "last_delivered_at", msg)
+}
+
+// advanceWatermark inserts row (a *models.ReadWatermark or
+// *models.DeliveryWatermark already pointing at msg), or moves the existing
+// row with its key forward to msg. idCol and atCol are the table's message
+// ID and sent_at columns.
+func advanceWatermark(tx *gorm.DB, row interface{}, idCol, atCol string, msg *models.Message) (bool, error) {
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(row)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		// Model(row) restricts the update to row's primary key
+		res = tx.Model(row).
+			Where(fmt.Sprintf("(%[1]s < ? OR (%[1]s = ? AND %[2]s < ?))", atCol, idCol), msg.SentAt, msg.SentAt, msg.ID).
+			Updates(map[string]interface{}{idCol: msg.ID, atCol: msg.SentAt})
+		if res.Error != nil {
+			return false, res.Error
+		}
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// ackDelivered records a client's acknowledgement that it received message
+// msgID. Acknowledging one's own message does nothing.
+func ackDelivered(db *gorm.DB, hub *realtime.Hub, userID, msgID string) error {
+	msg, err := authz.Message(db, msgID, userID)
+	if err != nil {
+		return err
+	}
+	if msg.SenderID == userID {
+		return nil
+	}
+	advanced, err := markDeliveredUpTo(db, userID, msg)
+	if err != nil {
+		return err
+	}
+	if advanced {
+		publishReceipt(db, hub, "message.delivered", userID, msg)
+	}
+	return nil
+}
+
+// deliverPage records that userID's client received msgs (a page fetched
+// from the API): the newest message someone else sent moves the delivery
+// watermark.
+func deliverPage(db *gorm.DB, hub *realtime.Hub, userID string, msgs []models.Message) {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].SenderID == userID {
+			continue
+		}
+		advanced, err := markDeliveredUpTo(db, userID, &msgs[i])
+		if err != nil {
+			// the page has been served either way; the receipt catches up
+			// with the next fetch or ws ack
+			log.Printf("delivery watermark for user %s at %s: %v", userID, msgs[i].ID, err)
+			return
+		}
+		if advanced {
+			publishReceipt(db, hub, "message.delivered", userID, &msgs[i])
+		}
+		return
+	}
+}
+
+// publishReceipt tells the conversation that userID has received (or read)
+// everything up to msg, so senders can update their receipts.
+func publishReceipt(db *gorm.DB, hub *realtime.Hub, eventType, userID string, msg *models.Message) {
+	ids, err := participantIDs(db, msg)
+	if err != nil {
+		return
+	}
+	hub.Publish(ids, realtime.Event{
+		Type: eventType,
+		Data: gin.H{"message_id": msg.ID, "user_id": userID, "group_id": msg.GroupID,
+			"receiver_id": msg.ReceiverID, "thread_root_id": msg.ThreadRootID, "at": time.Now()},
+	})
+}
+
+// watermarkPassed is true when the joined watermark alias (columns id / at)
+// is at or after the message row m.
+func watermarkPassed(alias, idCol, atCol string) string {
+	return fmt.Sprintf("(%[1]s.user_id IS NOT NULL AND (%[1]s.%[3]s > m.sent_at OR (%[1]s.%[3]s = m.sent_at AND %[1]s.%[2]s >= m.id)))",
+		alias, idCol, atCol)
+}
+
+// receiptRows returns, for every message in ids, each recipient and whether
+// the message was delivered to / read by them. Group recipients are the
+// members who had joined when it was sent, except the sender.
+func receiptRows(db *gorm.DB, ids []string) ([]receiptRow, error) {
+	read := watermarkPassed("rw", "last_read_message_id", "last_read_at")
+	delivered := watermarkPassed("dw", "last_delivered_message_id", "last_delivered_at")
+	key := "CASE WHEN m.thread_root_id IS NOT NULL THEN CONCAT('thread:', m.thread_root_id) " +
+		"WHEN m.group_id IS NOT NULL THEN CONCAT('group:', m.group_id) ELSE CONCAT('dm:', m.sender_id) END"
+
+	var rows []receiptRow
+	err := db.Raw(`
+		SELECT m.id AS message_id, r.user_id,
+			CASE WHEN `+read+` THEN 1 ELSE 0 END AS is_read,
+			CASE WHEN `+read+` OR `+delivered+` THEN 1 ELSE 0 END AS is_delivered
+		FROM messages m
+		JOIN (
+			SELECT m2.id AS message_id, gm.user_id
+			FROM messages m2
+			JOIN group_members gm ON gm.group_id = m2.group_id AND gm.deleted_at IS NULL
+				AND gm.user_id <> m2.sender_id AND gm.joined_at <= m2.sent_at
+			WHERE m2.id IN ?
+			UNION ALL
+			SELECT m3.id, m3.receiver_id
+			FROM messages m3
+			WHERE m3.id IN ? AND m3.group_id IS NULL AND m3.receiver_id <> m3.sender_id
+		) r ON r.message_id = m.id
+		LEFT JOIN read_watermarks rw ON rw.user_id = r.user_id AND rw.conversation_key = `+key+`
+		LEFT JOIN delivery_watermarks dw ON dw.user_id = r.user_id AND dw.conversation_key = `+key+`
+		WHERE m.id IN ?`, ids, ids, ids).Scan(&rows).Error
+	return rows, err
+}
+
+type receiptRow struct {
+	MessageID   string
+	UserID      string
+	IsRead      bool
+	IsDelivered bool
+}
+
+// fillReceipts sets Receipts on the messages in msgs that userID sent.
+func fillReceipts(db *gorm.DB, userID string, msgs []models.Message) error {
+	var own []string
+	for _, m := range msgs {
+		if m.SenderID == userID {
+			own = append(own, m.ID)
+		}
+	}
+	if len(own) == 0 {
+		return nil
+	}
+
+	rows, err := receiptRows(db, own)
+	if err != nil {
+		return err
+	}
+	sums := make(map[string]*models.ReceiptSummary, len(own))
+	for _, id := range own {
+		sums[id] = &models.ReceiptSummary{}
+	}
+	for _, r := range rows {
+		s := sums[r.MessageID]
+		s.Recipients++
+		if r.IsDelivered {
+			s.Delivered++
+		}
+		if r.IsRead {
+			s.Read++
+		}
+	}
+	for i := range msgs {
+		if s, ok := sums[msgs[i].ID]; ok {
+			msgs[i].Receipts = s
+		}
+	}
+	return nil
+}
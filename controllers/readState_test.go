@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"chat-app/models"
+)
+
+// sqlRecorder is a gorm logger that keeps every statement it is handed.
+type sqlRecorder struct {
+	logger.Interface
+	stmts []string
+}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.stmts = append(r.stmts, sql)
+}
+
+// dryMySQL opens a MySQL-dialect gorm.DB that records statements instead of
+// running them.
+func dryMySQL(t *testing.T) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	rec := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:pass@tcp(127.0.0.1:3306)/chat?parseTime=true",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true, Logger: rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, rec
+}
+
+func TestAdvanceWatermarkMySQL(t *testing.T) {
+	group := "g1"
+	msg := &models.Message{ID: "m1", SenderID: "u2", GroupID: &group, SentAt: time.Now()}
+
+	tests := []struct {
+		name  string
+		table string
+		run   func(db *gorm.DB) (bool, error)
+	}{
+		{"delivery", "delivery_watermarks", func(db *gorm.DB) (bool, error) {
+			return markDeliveredUpTo(db, "u1", msg)
+		}},
+		{"read", "read_watermarks", func(db *gorm.DB) (bool, error) {
+			read := &models.ReadWatermark{UserID: "u1", ConversationKey: "group:g1", LastReadMessageID: msg.ID, LastReadAt: msg.SentAt}
+			return advanceWatermark(db, read, "last_read_message_id", "last_read_at", msg)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, rec := dryMySQL(t)
+			if _, err := tt.run(db); err != nil {
+				t.Fatal(err)
+			}
+			if len(rec.stmts) != 2 {
+				t.Fatalf("got %d statements, want insert and update: %q", len(rec.stmts), rec.stmts)
+			}
+
+			insert := rec.stmts[0]
+			if !strings.HasPrefix(insert, "INSERT INTO `"+tt.table+"`") {
+				t.Errorf("insert = %q", insert)
+			}
+			// DoNothing must render an assignment, not a dangling clause
+			if i := strings.Index(insert, "ON DUPLICATE KEY UPDATE"); i < 0 ||
+				strings.TrimSpace(insert[i+len("ON DUPLICATE KEY UPDATE"):]) == "" {
+				t.Errorf("insert has no valid conflict clause: %q", insert)
+			}
+			if !strings.Contains(insert, "'group:g1'") {
+				t.Errorf("insert misses the conversation key: %q", insert)
+			}
+
+			update := rec.stmts[1]
+			if !strings.HasPrefix(update, "UPDATE `"+tt.table+"` SET") {
+				t.Errorf("update = %q", update)
+			}
+			for _, want := range []string{"`user_id` = 'u1'", "`conversation_key` = 'group:g1'", "`updated_at`="} {
+				if !strings.Contains(update, want) {
+					t.Errorf("update misses %s: %q", want, update)
+				}
+			}
+		})
+	}
+}
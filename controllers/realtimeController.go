@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+
+	"chat-app/authz"
+	"chat-app/realtime"
+)
+
+type RealtimeController struct {
+	DB       *gorm.DB
+	Hub      *realtime.Hub
+	Presence *realtime.Presence
+	Typing   *realtime.Typing
+}
+
+func NewRealtimeController(db *gorm.DB, hub *realtime.Hub, presence *realtime.Presence, typing *realtime.Typing) *RealtimeController {
+	return &RealtimeController{DB: db, Hub: hub, Presence: presence, Typing: typing}
+}
+
+type typingInput struct {
+	GroupID    *string `json:"group_id"`
+	ReceiverID *string `json:"receiver_id"`
+	Typing     *bool   `json:"typing"` // default true; false stops the indicator
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// auth is done with the bearer token, not cookies, so cross-origin
+	// upgrades are fine
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// ServeWS (GET /api/ws) — upgrades to a websocket and pushes live events
+// (new messages, ...) to the current user until the socket is closed.
+// Clients may send "heartbeat", "typing" and "delivered" frames.
+func (rc *RealtimeController) ServeWS(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// upgrader already wrote the error response
+		return
+	}
+
+	client := rc.Hub.Register(userID, c.GetString("sessionID"))
+	rc.Presence.Heartbeat(userID)
+	realtime.Serve(rc.Hub, conn, client, func(in realtime.Inbound) {
+		rc.handleInbound(userID, in)
+	})
+}
+
+// handleInbound dispatches a frame received over a user's websocket.
+func (rc *RealtimeController) handleInbound(userID string, in realtime.Inbound) {
+	switch in.Type {
+	case "heartbeat":
+		rc.Presence.Heartbeat(userID)
+	case "typing":
+		var input typingInput
+		if err := json.Unmarshal(in.Data, &input); err != nil {
+			return
+		}
+		// nothing to report back on a socket; unauthorized signals are dropped
+		rc.setTyping(userID, &input)
+	case "delivered":
+		var input struct {
+			MessageID string `json:"message_id"`
+		}
+		if err := json.Unmarshal(in.Data, &input); err != nil || input.MessageID == "" {
+			return
+		}
+		ackDelivered(rc.DB, rc.Hub, userID, input.MessageID)
+	}
+}
+
+// SetTyping (POST /api/typing) — starts or stops the caller's typing
+// indicator in a group (group_id) or 1‑on‑1 conversation (receiver_id).
+// Indicators expire on their own after a few seconds.
+func (rc *RealtimeController) SetTyping(c *gin.Context) {
+	var input typingInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if status, err := rc.setTyping(c.GetString("userID"), &input); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+func (rc *RealtimeController) setTyping(userID string, input *typingInput) (int, error) {
+	if input.Typing != nil && !*input.Typing {
+		rc.Typing.Stop(userID, input.GroupID, input.ReceiverID)
+		return http.StatusOK, nil
+	}
+
+	if err := authz.Conversation(rc.DB, userID, input.GroupID, input.ReceiverID); err != nil {
+		return authz.Status(err), err
+	}
+
+	var recipients []string
+	if input.GroupID != nil {
+		ids, err := groupMemberIDs(rc.DB, *input.GroupID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		for _, id := range ids {
+			if id != userID {
+				recipients = append(recipients, id)
+			}
+		}
+	} else {
+		recipients = []string{*input.ReceiverID}
+	}
+
+	rc.Typing.Start(userID, input.GroupID, input.ReceiverID, recipients)
+	return http.StatusOK, nil
+}
+
+// Heartbeat (POST /api/presence/heartbeat) — keeps the caller online for
+// clients that aren't on a websocket (SSE, polling).
+func (rc *RealtimeController) Heartbeat(c *gin.Context) {
+	rc.Presence.Heartbeat(c.GetString("userID"))
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+// sseKeepAlive is how often a comment line is written to idle event streams
+// so proxies don't time the connection out.
+const sseKeepAlive = 25 * time.Second
+
+// StreamEvents (GET /api/events) — Server-Sent Events fallback for clients
+// that can't use websockets. Carries the same events as /api/ws. Clients
+// resume with the Last-Event-ID header (or ?last_event_id=); if events were
+// lost in between a "stream.reset" event tells them to refetch.
+func (rc *RealtimeController) StreamEvents(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+
+	client, missed, complete := rc.Hub.Resume(userID, c.GetString("sessionID"), lastID)
+	defer rc.Hub.Unregister(client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !complete {
+		sse.Encode(c.Writer, sse.Event{Event: "stream.reset", Data: gin.H{}})
+	}
+	for _, evt := range missed {
+		writeSSE(c.Writer, evt)
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case evt, ok := <-client.Send:
+			if !ok {
+				// dropped by the hub: too slow, or its session was revoked
+				return
+			}
+			if err := writeSSE(c.Writer, evt); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-ticker.C:
+			if _, err := io.WriteString(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+func writeSSE(w io.Writer, evt realtime.Event) error {
+	return sse.Encode(w, sse.Event{
+		Id:    evt.ID,
+		Event: evt.Type,
+		Data:  evt,
+	})
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"chat-app/authz"
+	"chat-app/models"
+	"chat-app/search"
+)
+
+type SearchController struct {
+	DB    *gorm.DB
+	Index search.Index
+}
+
+func NewSearchController(db *gorm.DB, idx search.Index) *SearchController {
+	return &SearchController{DB: db, Index: idx}
+}
+
+type searchResult struct {
+	Message models.Message `json:"message"`
+	Score   float64        `json:"score"`
+	Snippet string         `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+}
+
+// SearchMessages (GET /api/search/messages)
+// query params: q (required; every word must match, "word*" matches words
+// starting with it), and optionally sender_id, group_id, from / to
+// (RFC 3339), has_attachment (true/false), limit and offset. Searches every
+// group the caller is a member of and their 1‑on‑1 conversations; results
+// are ranked best match first.
+func (sc *SearchController) SearchMessages(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	q := search.Query{
+		Terms:    search.Terms(c.Query("q")),
+		UserID:   userID,
+		SenderID: c.Query("sender_id"),
+		GroupID:  c.Query("group_id"),
+	}
+	if len(q.Terms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	var err error
+	if q.Limit, err = limitParam(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if v := c.Query("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return
+		}
+	}
+	for name, dst := range map[string]**time.Time{"from": &q.From, "to": &q.To} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an RFC 3339 timestamp"})
+				return
+			}
+			*dst = &t
+		}
+	}
+	if v := c.Query("has_attachment"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "has_attachment must be true or false"})
+			return
+		}
+		q.HasAttachment = &b
+	}
+
+	if q.GroupID != "" {
+		if err := authz.Group(sc.DB, q.GroupID, userID); err != nil {
+			abortWithAuthzError(c, err)
+			return
+		}
+	}
+	if err := sc.DB.Model(&models.GroupMember{}).
+		Joins("JOIN chat_groups ON chat_groups.id = group_members.group_id AND chat_groups.deleted_at IS NULL").
+		Where("group_members.user_id = ?", userID).
+		Pluck("group_members.group_id", &q.GroupIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := sc.Index.Search(c.Request.Context(), q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := make([]string, len(res.Hits))
+	for i, h := range res.Hits {
+		ids[i] = h.MessageID
+	}
+	var msgs []models.Message
+	if len(ids) > 0 {
+		if err := sc.DB.Preload("Sender").Preload("Attachments.Thumbnails").
+			Where("id IN ?", ids).Find(&msgs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	byID := make(map[string]models.Message, len(msgs))
+	for _, m := range msgs {
+		m.Sender.Password = ""
+		byID[m.ID] = m
+	}
+
+	// keep the index's ranking; skip anything deleted since it was indexed
+	results := make([]searchResult, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		if m, ok := byID[h.MessageID]; ok {
+			results = append(results, searchResult{Message: m, Score: h.Score, Snippet: h.Snippet})
+		}
+	}
+
+	var next *int
+	if end := q.Offset + len(res.Hits); int64(end) < res.Total {
+		next = &end
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results, "total": res.Total, "next_offset": next})
+}
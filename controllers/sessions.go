@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"chat-app/models"
+)
+
+// sessionTouchInterval limits how often a session's last_used_at is written.
+const sessionTouchInterval = time.Minute
+
+// sessionView is a session as listed to its user. Like the embedded model
+// it has no json tags, so Current is spelled like the other keys.
+type sessionView struct {
+	models.Session
+	Current bool
+}
+
+// GetSessions (GET /api/sessions) — the caller's active sessions, most
+// recently used first.
+func (uc *UserController) GetSessions(c *gin.Context) {
+	var sessions []models.Session
+	if err := uc.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", c.GetString("userID"), time.Now()).
+		Order("last_used_at desc").
+		Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	current := c.GetString("sessionID")
+	views := make([]sessionView, len(sessions))
+	for i, s := range sessions {
+		views[i] = sessionView{Session: s, Current: s.ID == current}
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+// RevokeSession (DELETE /api/sessions/:id) — signs out one of the caller's
+// sessions (possibly the current one).
+func (uc *UserController) RevokeSession(c *gin.Context) {
+	userID := c.GetString("userID")
+	id := c.Param("id")
+
+	var count int64
+	if err := uc.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	var revoked []string
+	if err := uc.DB.Transaction(func(tx *gorm.DB) (err error) {
+		revoked, err = revokeSessions(tx, "id = ? AND user_id = ?", id, userID)
+		return err
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	uc.closeSessions(revoked)
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeOtherSessions (DELETE /api/sessions/others) — signs out every
+// session of the caller except the current one.
+func (uc *UserController) RevokeOtherSessions(c *gin.Context) {
+	var revoked []string
+	if err := uc.DB.Transaction(func(tx *gorm.DB) (err error) {
+		revoked, err = revokeSessions(tx, "user_id = ? AND id <> ?", c.GetString("userID"), c.GetString("sessionID"))
+		return err
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	uc.closeSessions(revoked)
+	c.JSON(http.StatusOK, gin.H{"message": "other sessions revoked"})
+}
+
+// touchSession records that a session was just used, at most once per
+// sessionTouchInterval so not every request writes to the database.
+func (uc *UserController) touchSession(sessionID string) {
+	now := time.Now()
+	if last, ok := uc.touched.Load(sessionID); ok && now.Sub(last.(time.Time)) < sessionTouchInterval {
+		return
+	}
+	uc.touched.Store(sessionID, now)
+	uc.DB.Model(&models.Session{}).Where("id = ?", sessionID).Update("last_used_at", now)
+
+	// forget sessions that haven't been seen for a while, so the map only
+	// holds recently used ones; at most one request per interval does this
+	if last := uc.touchPruned.Load(); now.UnixNano()-last >= int64(sessionTouchInterval) &&
+		uc.touchPruned.CompareAndSwap(last, now.UnixNano()) {
+		uc.touched.Range(func(id, at interface{}) bool {
+			if now.Sub(at.(time.Time)) >= sessionTouchInterval {
+				uc.touched.Delete(id)
+			}
+			return true
+		})
+	}
+}
+
+// truncate cuts s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
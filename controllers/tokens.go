@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chat-app/models"
+)
+
+var (
+	errRefreshInvalid = errors.New("invalid or expired refresh token")
+	errRefreshReused  = errors.New("refresh token was already used; all sessions of this login have been revoked")
+)
+
+// tokenTTLs reads ACCESS_TOKEN_TTL_MINUTES (default 15) and
+// REFRESH_TOKEN_TTL_DAYS (default 30).
+func tokenTTLs() (access, refresh time.Duration) {
+	access, refresh = 15*time.Minute, 30*24*time.Hour
+	if v, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_TTL_MINUTES")); err == nil && v > 0 {
+		access = time.Duration(v) * time.Minute
+	}
+	if v, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_TTL_DAYS")); err == nil && v > 0 {
+		refresh = time.Duration(v) * 24 * time.Hour
+	}
+	return access, refresh
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens creates an access token and a refresh token in session
+// familyID for user and returns the token part of the login / refresh
+// response.
+func (uc *UserController) issueTokens(tx *gorm.DB, user *models.User, familyID string) (gin.H, error) {
+	jti := uuid.NewString()
+	access, err := uc.generateToken(user, familyID, jti, uc.AccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := tx.Create(&models.RefreshToken{
+		ID:              uuid.NewString(),
+		FamilyID:        familyID,
+		UserID:          user.ID,
+		TokenHash:       hashToken(refresh),
+		AccessJTI:       jti,
+		AccessExpiresAt: now.Add(uc.AccessTTL),
+		ExpiresAt:       now.Add(uc.RefreshTTL),
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Session{}).Where("id = ?", familyID).
+		Update("expires_at", now.Add(uc.RefreshTTL)).Error; err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"access_token":       access,
+		"token_type":         "bearer",
+		"expires_in":         int(uc.AccessTTL.Seconds()),
+		"refresh_token":      refresh,
+		"refresh_expires_in": int(uc.RefreshTTL.Seconds()),
+	}, nil
+}
+
+// RefreshToken (POST /api/token/refresh) — body: {"refresh_token": "..."}.
+// Returns a new access token and a new refresh token; the old refresh token
+// can't be used again. Presenting an already-used refresh token means it
+// leaked, so the whole login it belongs to is revoked.
+func (uc *UserController) RefreshToken(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resp gin.H
+	var reused bool
+	var revoked []string
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		var rt models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&rt, "token_hash = ?", hashToken(input.RefreshToken)).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errRefreshInvalid
+			}
+			return err
+		}
+		if rt.UsedAt != nil {
+			// commit the revocation, the request still fails below
+			reused = true
+			var err error
+			revoked, err = revokeSessions(tx, "id = ?", rt.FamilyID)
+			return err
+		}
+		if rt.RevokedAt != nil || rt.ExpiresAt.Before(time.Now()) {
+			return errRefreshInvalid
+		}
+
+		var user models.User
+		if err := tx.First(&user, "id = ?", rt.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errRefreshInvalid
+			}
+			return err
+		}
+
+		if err := tx.Model(&rt).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Session{}).Where("id = ?", rt.FamilyID).Updates(map[string]interface{}{
+			"last_used_at": time.Now(),
+			"ip":           c.ClientIP(),
+			"user_agent":   truncate(c.Request.UserAgent(), 255),
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		resp, err = uc.issueTokens(tx, &user, rt.FamilyID)
+		return err
+	})
+	if err == nil && reused {
+		uc.closeSessions(revoked)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errRefreshReused.Error()})
+		return
+	}
+	if errors.Is(err, errRefreshInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// revokeSessions signs out the sessions matching the condition and returns
+// their IDs, for closeSessions once the transaction commits. They are
+// marked revoked and their tokens can't be used any more.
+func revokeSessions(tx *gorm.DB, query string, args ...interface{}) ([]string, error) {
+	var ids []string
+	if err := tx.Model(&models.Session{}).Where(query, args...).Where("revoked_at IS NULL").
+		Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if err := revokeTokens(tx, "family_id IN ?", ids); err != nil {
+		return nil, err
+	}
+	return ids, tx.Model(&models.Session{}).Where("id IN ?", ids).Update("revoked_at", time.Now()).Error
+}
+
+// closeSessions drops the live websocket and SSE connections of revoked
+// sessions.
+func (uc *UserController) closeSessions(ids []string) {
+	uc.Presence.Hub.CloseSessions(ids)
+}
+
+// revokeTokens revokes the refresh tokens matching the condition and puts
+// the access tokens issued with them on the revocation list.
+func revokeTokens(tx *gorm.DB, query string, args ...interface{}) error {
+	now := time.Now()
+
+	var live []models.RefreshToken
+	if err := tx.Where(query, args...).Where("access_expires_at > ?", now).Find(&live).Error; err != nil {
+		return err
+	}
+	if len(live) > 0 {
+		revoked := make([]models.RevokedToken, len(live))
+		for i, t := range live {
+			revoked[i] = models.RevokedToken{JTI: t.AccessJTI, ExpiresAt: t.AccessExpiresAt}
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&models.RefreshToken{}).
+		Where(query, args...).Where("revoked_at IS NULL").
+		Update("revoked_at", now).Error
+}
+
+// tokenRevoked reports whether the access token jti is on the revocation
+// list.
+func (uc *UserController) tokenRevoked(jti string) (bool, error) {
+	var count int64
+	err := uc.DB.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
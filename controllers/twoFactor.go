@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chat-app/models"
+)
+
+const (
+	// challengeTTL is how long the password step of a 2FA login stays valid.
+	challengeTTL = 5 * time.Minute
+	// challengeAudience keeps challenge tokens from being used as access
+	// tokens and the other way round.
+	challengeAudience = "2fa-challenge"
+	totpPeriod        = 30 // seconds
+	recoveryCodeCount = 10
+)
+
+var (
+	errInvalidCode      = errors.New("invalid code")
+	errTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	errTwoFactorNoSetup = errors.New("call POST /api/2fa/setup first")
+)
+
+type challengeClaims struct {
+	UserID     string `json:"uid"`
+	DeviceName string `json:"device,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (uc *UserController) generateChallenge(user *models.User, deviceName string) (string, error) {
+	claims := challengeClaims{
+		UserID:     user.ID,
+		DeviceName: deviceName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{challengeAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(challengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   user.ID,
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(uc.SecretKey)
+}
+
+// LoginTwoFactor (POST /api/login/2fa) — second login step for accounts with
+// 2FA: body {"challenge_token": ..., "code": ...} where code is the current
+// TOTP code or an unused recovery code. Responds like Login.
+func (uc *UserController) LoginTwoFactor(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code"            binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims := &challengeClaims{}
+	tkn, err := jwt.ParseWithClaims(input.ChallengeToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return uc.SecretKey, nil
+	}, jwt.WithAudience(challengeAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !tkn.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge token"})
+		return
+	}
+
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", claims.UserID).Error; err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge token"})
+		return
+	}
+
+	// wrong codes count against the account like wrong passwords
+	if !uc.reserveLoginAttempt(c, user.Email) {
+		return
+	}
+	if err := uc.checkSecondFactor(&user, input.Code); err != nil {
+		if errors.Is(err, errInvalidCode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	uc.startSession(c, &user, claims.DeviceName)
+}
+
+// SetupTwoFactor (POST /api/2fa/setup) — starts enrollment: generates a new
+// secret and returns it as an otpauth:// URI (and QR code PNG) for an
+// authenticator app. 2FA is active only after POST /api/2fa/enable.
+func (uc *UserController) SetupTwoFactor(c *gin.Context) {
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", c.GetString("userID")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": errTwoFactorEnabled.Error()})
+		return
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "chat-app"
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: issuer, AccountName: user.Email, Period: totpPeriod})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := uc.DB.Model(&user).Updates(map[string]interface{}{"totp_secret": key.Secret(), "totp_last_step": 0}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{"secret": key.Secret(), "otpauth_url": key.URL()}
+	if img, err := key.Image(256, 256); err == nil {
+		var buf bytes.Buffer
+		if png.Encode(&buf, img) == nil {
+			resp["qr_code"] = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// EnableTwoFactor (POST /api/2fa/enable) — body {"code": ...}: confirms the
+// secret from setup with a code from the app and turns 2FA on. Returns the
+// recovery codes; they are shown only this once.
+func (uc *UserController) EnableTwoFactor(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var codes []string
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", c.GetString("userID")).Error; err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return errTwoFactorEnabled
+		}
+		if user.TOTPSecret == "" {
+			return errTwoFactorNoSetup
+		}
+		step, ok := validateTOTP(user.TOTPSecret, input.Code, user.TOTPLastStep)
+		if !ok {
+			return errInvalidCode
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	switch {
+	case errors.Is(err, errInvalidCode), errors.Is(err, errTwoFactorNoSetup):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errTwoFactorEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// DisableTwoFactor (POST /api/2fa/disable) — body {"code": ...}: a current
+// TOTP code or a recovery code is required to turn 2FA off.
+func (uc *UserController) DisableTwoFactor(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", c.GetString("userID")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	if err := uc.checkSecondFactor(&user, input.Code); err != nil {
+		if errors.Is(err, errInvalidCode) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled": false, "totp_secret": "", "totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// checkSecondFactor accepts a TOTP code (each time step only once) or an
+// unused recovery code, which is then used up.
+func (uc *UserController) checkSecondFactor(user *models.User, code string) error {
+	return uc.DB.Transaction(func(tx *gorm.DB) error {
+		// lock so two requests can't both accept the same code
+		var locked models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id, totp_secret, totp_last_step").First(&locked, "id = ?", user.ID).Error; err != nil {
+			return err
+		}
+		if step, ok := validateTOTP(locked.TOTPSecret, code, locked.TOTPLastStep); ok {
+			return tx.Model(&locked).Update("totp_last_step", step).Error
+		}
+
+		res := tx.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+			Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errInvalidCode
+		}
+		return nil
+	})
+}
+
+// validateTOTP checks code against secret, allowing one step of clock skew
+// either way. Steps up to lastStep were already used and are rejected.
+func validateTOTP(secret, code string, lastStep int64) (int64, bool) {
+	if secret == "" || len(code) != 6 {
+		return 0, false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for _, step := range []int64{now - 1, now, now + 1} {
+		if step <= lastStep {
+			continue
+		}
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and creates a new
+// set, returned in plain text.
+func replaceRecoveryCodes(tx *gorm.DB, userID string) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		rows[i] = models.RecoveryCode{ID: uuid.NewString(), UserID: userID, CodeHash: hashToken(raw)}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes with or without the
+// dash and in any case.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
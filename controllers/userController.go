@@ -2,9 +2,12 @@ package controllers
 
 import (
     "errors"
+    "log"
     "net/http"
     "os"
     "strings"
+    "sync"
+    "sync/atomic"
     "time"
 
     "github.com/gin-gonic/gin"
@@ -13,21 +16,46 @@ import (
     "golang.org/x/crypto/bcrypt"
     "gorm.io/gorm"
 
+    "chat-app/authz"
+    "chat-app/mailer"
     "chat-app/models"
+    "chat-app/realtime"
 )
 
 
 type UserController struct {
-    DB        *gorm.DB
-    SecretKey []byte
+    DB         *gorm.DB
+    SecretKey  []byte
+    Presence   *realtime.Presence
+    AccessTTL  time.Duration
+    RefreshTTL time.Duration
+    Mailer     mailer.Mailer
+    AppURL     string // base of the links in emails, without trailing slash
+
+    MaxLoginFailures int           // failed logins before an email is locked out
+    LoginLockout     time.Duration // how long a lockout lasts
+
+    touched     sync.Map      // session ID -> time.Time its last_used_at was written
+    touchPruned atomic.Int64  // unix nanos of the last sweep of touched
+    mailJobs    chan struct{} // one slot per running mail job, see maxMailJobs
 }
 
-func NewUserController(db *gorm.DB) *UserController {
+func NewUserController(db *gorm.DB, presence *realtime.Presence, mail mailer.Mailer) *UserController {
     secret := []byte(os.Getenv("JWT_SECRET"))
     if len(secret) == 0 {
         secret = []byte("change-me-please")
     }
-    return &UserController{DB: db, SecretKey: secret}
+    appURL := strings.TrimRight(os.Getenv("APP_URL"), "/")
+    if appURL == "" {
+        appURL = "http://localhost:8080"
+    }
+    access, refresh := tokenTTLs()
+    maxFailures, lockout := loginLimits()
+    return &UserController{
+        DB: db, SecretKey: secret, Presence: presence, AccessTTL: access, RefreshTTL: refresh,
+        Mailer: mail, AppURL: appURL, MaxLoginFailures: maxFailures, LoginLockout: lockout,
+        mailJobs: make(chan struct{}, maxMailJobs),
+    }
 }
 
 // ======== Request structs ========
@@ -39,30 +67,41 @@ type registerInput struct {
 }
 
 type loginInput struct {
-    Email    string `json:"email"    binding:"required,email"`
-    Password string `json:"password" binding:"required"`
+    Email      string `json:"email"       binding:"required,email"`
+    Password   string `json:"password"    binding:"required"`
+    DeviceName string `json:"device_name" binding:"max=100"` // optional, shown in the session list
 }
 
 type updateInput struct {
-    Username *string `json:"username" binding:"omitempty,min=3,max=50"`
-    Email    *string `json:"email"    binding:"omitempty,email,max=100"`
-    Password *string `json:"password" binding:"omitempty,min=6,max=100"`
-    IsOnline *bool   `json:"is_online"`
+    Username        *string `json:"username"         binding:"omitempty,min=3,max=50"`
+    Email           *string `json:"email"            binding:"omitempty,email,max=100"`
+    Password        *string `json:"password"         binding:"omitempty,min=6,max=100"`
+    CurrentPassword *string `json:"current_password"` // required to change your own password
 }
 
 // ======== JWT helpers & middleware ========
 
+// queryTokenRoutes may authenticate with ?access_token= instead of the
+// Authorization header.
+var queryTokenRoutes = map[string]bool{
+    "/api/ws":     true,
+    "/api/events": true,
+}
+
 type jwtCustomClaims struct {
-    UserID   string `json:"uid"`
-    Username string `json:"uname"`
+    UserID    string `json:"uid"`
+    Username  string `json:"uname"`
+    SessionID string `json:"sid"`
     jwt.RegisteredClaims
 }
 
-func (uc *UserController) generateToken(user *models.User, ttl time.Duration) (string, error) {
+func (uc *UserController) generateToken(user *models.User, sessionID, jti string, ttl time.Duration) (string, error) {
     claims := jwtCustomClaims{
-        UserID:   user.ID,
-        Username: user.Username,
+        UserID:    user.ID,
+        Username:  user.Username,
+        SessionID: sessionID,
         RegisteredClaims: jwt.RegisteredClaims{
+            ID:        jti,
             ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
             Subject:   user.ID,
@@ -80,7 +119,7 @@ func (uc *UserController) parseToken(tokenStr string) (*jwtCustomClaims, error)
         return nil, err
     }
     claims, ok := tkn.Claims.(*jwtCustomClaims)
-    if !ok || !tkn.Valid {
+    if !ok || !tkn.Valid || claims.ID == "" || claims.SessionID == "" {
         return nil, errors.New("invalid token")
     }
     return claims, nil
@@ -90,8 +129,16 @@ func (uc *UserController) JWTAuthMiddleware() gin.HandlerFunc {
     return func(c *gin.Context) {
         authHeader := c.GetHeader("Authorization")
         if authHeader == "" {
-            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing Authorization header"})
-            return
+            // browsers can't set headers on websocket upgrades or
+            // EventSource, so those two may pass the token as
+            // ?access_token=... Nowhere else: query strings end up in access
+            // logs and Referer headers.
+            if tok := c.Query("access_token"); tok != "" && queryTokenRoutes[c.FullPath()] {
+                authHeader = "Bearer " + tok
+            } else {
+                c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing Authorization header"})
+                return
+            }
         }
         parts := strings.SplitN(authHeader, " ", 2)
         if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
@@ -103,8 +150,21 @@ func (uc *UserController) JWTAuthMiddleware() gin.HandlerFunc {
             c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
             return
         }
+        revoked, err := uc.tokenRevoked(claims.ID)
+        if err != nil {
+            c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if revoked {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+            return
+        }
+
+        uc.touchSession(claims.SessionID)
 
         c.Set("userID", claims.UserID)
+        c.Set("jti", claims.ID)
+        c.Set("sessionID", claims.SessionID)
         c.Set("username", claims.Username)
         c.Next()
     }
@@ -112,7 +172,7 @@ func (uc *UserController) JWTAuthMiddleware() gin.HandlerFunc {
 
 // ======== Handler methods ========
 
-// Register (POST /api/register)
+// Register (POST /api/register) — also mails a link to verify the address
 func (uc *UserController) Register(c *gin.Context) {
     var input registerInput
     if err := c.ShouldBindJSON(&input); err != nil {
@@ -140,6 +200,7 @@ func (uc *UserController) Register(c *gin.Context) {
         Username: input.Username,
         Email:    input.Email,
         Password: string(hashed),
+        Role:     models.RoleUser,
         IsOnline: false,
     }
 
@@ -147,11 +208,18 @@ func (uc *UserController) Register(c *gin.Context) {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    // the account exists either way; POST /api/email/verification resends
+    if _, err := uc.mailToken(&user, models.TokenVerifyEmail); err != nil {
+        log.Printf("verification email for user %s: %v", user.ID, err)
+    }
 
     c.JSON(http.StatusCreated, gin.H{"message": "registration successful"})
 }
 
-// Login (POST /api/login) – returns JWT token
+// Login (POST /api/login) – returns a short-lived JWT access token and a
+// refresh token for POST /api/token/refresh. Repeated failures for an email
+// or IP slow down further attempts and eventually lock them out for a while
+// (429 with Retry-After).
 func (uc *UserController) Login(c *gin.Context) {
     var input loginInput
     if err := c.ShouldBindJSON(&input); err != nil {
@@ -159,51 +227,118 @@ func (uc *UserController) Login(c *gin.Context) {
         return
     }
 
+    if !uc.reserveLoginAttempt(c, input.Email) {
+        return
+    }
+
     var user models.User
-    if err := uc.DB.Where("email = ?", input.Email).First(&user).Error; err != nil {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+    err := uc.DB.Where("email = ?", input.Email).First(&user).Error
+    if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
 
-    if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+    // unknown emails still pay for a bcrypt compare, see dummyPasswordHash
+    hash := dummyPasswordHash
+    if err == nil {
+        hash = []byte(user.Password)
+    }
+    if bcrypt.CompareHashAndPassword(hash, []byte(input.Password)) != nil || err != nil {
         c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
         return
     }
 
-    // mark online and reset LastSeen
-    uc.DB.Model(&user).Updates(models.User{IsOnline: true, LastSeen: nil})
+    // the password alone isn't enough with 2FA on: hand out a challenge
+    // to redeem with a code at POST /api/login/2fa
+    if user.TOTPEnabled {
+        if err := uc.loginSucceeded(c, user.Email, false); err != nil {
+            log.Printf("release login attempt: %v", err)
+        }
+        challenge, err := uc.generateChallenge(&user, input.DeviceName)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "two_factor_required": true,
+            "challenge_token":     challenge,
+            "expires_in":          int(challengeTTL.Seconds()),
+        })
+        return
+    }
+
+    uc.startSession(c, &user, input.DeviceName)
+}
 
-    token, err := uc.generateToken(&user, 24*time.Hour)
+// startSession creates a new session for user (logging them in) and
+// responds with its tokens.
+func (uc *UserController) startSession(c *gin.Context, user *models.User, deviceName string) {
+    // logging in counts as a heartbeat
+    uc.Presence.Heartbeat(user.ID)
+    user.IsOnline = true
+    user.LastSeen = nil
+
+    var resp gin.H
+    err := uc.DB.Transaction(func(tx *gorm.DB) error {
+        now := time.Now()
+        session := models.Session{
+            ID:         uuid.NewString(),
+            UserID:     user.ID,
+            DeviceName: deviceName,
+            IP:         c.ClientIP(),
+            UserAgent:  truncate(c.Request.UserAgent(), 255),
+            LastUsedAt: now,
+            ExpiresAt:  now.Add(uc.RefreshTTL),
+        }
+        if err := tx.Create(&session).Error; err != nil {
+            return err
+        }
+        var err error
+        if resp, err = uc.issueTokens(tx, user, session.ID); err != nil {
+            return err
+        }
+        resp["session_id"] = session.ID
+        return nil
+    })
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
         return
     }
 
-    user.Password = ""
+    if err := uc.loginSucceeded(c, user.Email, true); err != nil {
+        log.Printf("clear login failures: %v", err)
+    }
 
-    c.JSON(http.StatusOK, gin.H{
-        "access_token": token,
-        "token_type":   "bearer",
-        "expires_in":   86400,
-        "user": gin.H{
-            "id":        user.ID,
-            "username":  user.Username,
-            "email":     user.Email,
-            "is_online": user.IsOnline,
-            "last_seen": user.LastSeen,
-        },
-    })
+    resp["user"] = gin.H{
+        "id":             user.ID,
+        "username":       user.Username,
+        "email":          user.Email,
+        "email_verified": user.EmailVerifiedAt != nil,
+        "is_online":      user.IsOnline,
+        "last_seen":      user.LastSeen,
+    }
+    c.JSON(http.StatusOK, resp)
 }
 
-// Logout (POST /api/logout)
+// Logout (POST /api/logout) — ends the current session: its access and
+// refresh tokens stop working.
 func (uc *UserController) Logout(c *gin.Context) {
     uid, exists := c.Get("userID")
     if !exists {
         c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthenticated"})
         return
     }
-    now := time.Now()
-    if err := uc.DB.Model(&models.User{}).Where("id = ?", uid).Updates(models.User{IsOnline: false, LastSeen: &now}).Error; err != nil {
+    var revoked []string
+    err := uc.DB.Transaction(func(tx *gorm.DB) (err error) {
+        revoked, err = revokeSessions(tx, "id = ?", c.GetString("sessionID"))
+        return err
+    })
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    uc.closeSessions(revoked)
+    if err := uc.Presence.SetOffline(uid.(string)); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
@@ -240,15 +375,24 @@ func (uc *UserController) GetUser(c *gin.Context) {
     c.JSON(http.StatusOK, user)
 }
 
-// UpdateUser (PUT /api/users/:id)
+// UpdateUser (PUT /api/users/:id) — only the user themselves or an admin.
+// Changing your own password needs current_password and signs out all your
+// other logins (an admin reset signs out all of them); changing the email
+// marks the account unverified again and mails a link to the new address.
 func (uc *UserController) UpdateUser(c *gin.Context) {
     id := c.Param("id")
+    actorID := c.GetString("userID")
     var input updateInput
     if err := c.ShouldBindJSON(&input); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
+    if err := authz.Account(uc.DB, actorID, id); err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+
     var user models.User
     if err := uc.DB.First(&user, "id = ?", id).Error; err != nil {
         if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -259,14 +403,43 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
         return
     }
 
+    // uniqueness check for the new username / email
+    if input.Username != nil || input.Email != nil {
+        var count int64
+        q := uc.DB.Model(&models.User{}).Where("id <> ?", user.ID)
+        switch {
+        case input.Username != nil && input.Email != nil:
+            q = q.Where("username = ? OR email = ?", *input.Username, *input.Email)
+        case input.Username != nil:
+            q = q.Where("username = ?", *input.Username)
+        default:
+            q = q.Where("email = ?", *input.Email)
+        }
+        q.Count(&count)
+        if count > 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "username or email already registered"})
+            return
+        }
+    }
+
     // handle updates
     if input.Username != nil {
         user.Username = *input.Username
     }
-    if input.Email != nil {
+    emailChanged := input.Email != nil && *input.Email != user.Email
+    if emailChanged {
         user.Email = *input.Email
+        user.EmailVerifiedAt = nil
     }
     if input.Password != nil {
+        // admins resetting someone else's password don't know the old one
+        if actorID == user.ID {
+            if input.CurrentPassword == nil ||
+                bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(*input.CurrentPassword)) != nil {
+                c.JSON(http.StatusForbidden, gin.H{"error": "current password is incorrect"})
+                return
+            }
+        }
         hashed, err := bcrypt.GenerateFromPassword([]byte(*input.Password), bcrypt.DefaultCost)
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
@@ -274,25 +447,59 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
         }
         user.Password = string(hashed)
     }
-    if input.IsOnline != nil {
-        user.IsOnline = *input.IsOnline
-    }
 
-    if err := uc.DB.Save(&user).Error; err != nil {
+    var revoked []string
+    err := uc.DB.Transaction(func(tx *gorm.DB) (err error) {
+        if err := tx.Save(&user).Error; err != nil {
+            return err
+        }
+        if input.Password == nil {
+            return nil
+        }
+        if err := expireEmailTokens(tx, user.ID, models.TokenPasswordReset); err != nil {
+            return err
+        }
+        if actorID == user.ID {
+            revoked, err = revokeSessions(tx, "user_id = ? AND id <> ?", user.ID, c.GetString("sessionID"))
+        } else {
+            revoked, err = revokeSessions(tx, "user_id = ?", user.ID)
+        }
+        return err
+    })
+    if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    uc.closeSessions(revoked)
+    if emailChanged {
+        if _, err := uc.mailToken(&user, models.TokenVerifyEmail); err != nil {
+            log.Printf("verification email for user %s: %v", user.ID, err)
+        }
+    }
 
     user.Password = ""
     c.JSON(http.StatusOK, user)
 }
 
-// DeleteUser (DELETE /api/users/:id)
+// DeleteUser (DELETE /api/users/:id) — only the user themselves or an admin
 func (uc *UserController) DeleteUser(c *gin.Context) {
     id := c.Param("id")
-    if err := uc.DB.Delete(&models.User{}, "id = ?", id).Error; err != nil {
+    if err := authz.Account(uc.DB, c.GetString("userID"), id); err != nil {
+        abortWithAuthzError(c, err)
+        return
+    }
+    var revoked []string
+    err := uc.DB.Transaction(func(tx *gorm.DB) (err error) {
+        if err := tx.Delete(&models.User{}, "id = ?", id).Error; err != nil {
+            return err
+        }
+        revoked, err = revokeSessions(tx, "user_id = ?", id)
+        return err
+    })
+    if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    uc.closeSessions(revoked)
     c.JSON(http.StatusOK, gin.H{"message": "user deleted"})
 }
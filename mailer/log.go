@@ -0,0 +1,15 @@
+package mailer
+
+import (
+	"context"
+	"log"
+)
+
+// Log writes emails to the server log instead of sending them. Links in
+// them (reset, verification) can be copied from there.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,43 @@
+// Package mailer sends transactional email (password resets, address
+// verification) behind a small interface so the backend can be a real SMTP
+// server or just the log during development.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// Message is a plain-text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv builds the mailer configured by MAILER_DRIVER:
+//   - "log" (default): emails are written to the server log, not sent
+//   - "smtp": sent through SMTP_HOST (default localhost) on SMTP_PORT
+//     (default 25) as MAIL_FROM; SMTP_USERNAME / SMTP_PASSWORD enable PLAIN
+//     auth. A local sink like MailHog or smtp4dev works without auth.
+func FromEnv() (Mailer, error) {
+	switch os.Getenv("MAILER_DRIVER") {
+	case "", "log":
+		return Log{}, nil
+	case "smtp":
+		return NewSMTP(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		})
+	default:
+		return nil, errors.New("unknown MAILER_DRIVER " + os.Getenv("MAILER_DRIVER"))
+	}
+}
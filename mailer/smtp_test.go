@@ -0,0 +1,185 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sinkMail is what the test SMTP server received in one session.
+type sinkMail struct {
+	from, to string
+	data     string
+}
+
+// startSink runs a minimal SMTP server on a random local port that accepts
+// one message and reports it on the returned channel.
+func startSink(t *testing.T) (host, port string, got <-chan sinkMail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan sinkMail, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		var mail sinkMail
+		var data strings.Builder
+		inData := false
+		reply("220 sink ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					mail.data = data.String()
+					reply("250 queued")
+					continue
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+				continue
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 sink")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				mail.from = strings.TrimSpace(line[len("MAIL FROM:"):])
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				mail.to = strings.TrimSpace(line[len("RCPT TO:"):])
+				reply("250 ok")
+			case cmd == "DATA":
+				inData = true
+				reply("354 end with .")
+			case cmd == "QUIT":
+				reply("221 bye")
+				ch <- mail
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port, ch
+}
+
+func TestSMTPSend(t *testing.T) {
+	host, port, got := startSink(t)
+	m, err := NewSMTP(SMTPConfig{Host: host, Port: port, From: "noreply@chat.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = m.Send(ctx, Message{
+		To:      "alice@chat.test",
+		Subject: "Réinitialiser",
+		Body:    "Hi alice,\n\nopen this link\n",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var mail sinkMail
+	select {
+	case mail = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sink received nothing")
+	}
+	if mail.from != "<noreply@chat.test>" {
+		t.Errorf("MAIL FROM = %q", mail.from)
+	}
+	if mail.to != "<alice@chat.test>" {
+		t.Errorf("RCPT TO = %q", mail.to)
+	}
+
+	header, body, ok := strings.Cut(mail.data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no header/body separator in %q", mail.data)
+	}
+	for _, want := range []string{
+		"From: noreply@chat.test",
+		"To: alice@chat.test",
+		"Subject: =?utf-8?q?R=C3=A9initialiser?=",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(header+"\r\n", want+"\r\n") {
+			t.Errorf("header %q missing in:\n%s", want, header)
+		}
+	}
+	if !strings.Contains(header, "\r\nDate: ") {
+		t.Errorf("Date header missing in:\n%s", header)
+	}
+	if want := "Hi alice,\r\n\r\nopen this link\r\n"; body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestSMTPSendHonorsContext(t *testing.T) {
+	// a server that accepts the connection but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(5 * time.Second)
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	m, err := NewSMTP(SMTPConfig{Host: host, Port: port, From: "noreply@chat.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.Send(ctx, Message{To: "alice@chat.test", Subject: "s", Body: "b"}); err == nil {
+		t.Fatal("Send succeeded against a silent server")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Send returned after %v, want about the context timeout", d)
+	}
+}
+
+func TestSMTPRejectsHeaderInjection(t *testing.T) {
+	m, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: "1", From: "noreply@chat.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), Message{To: "a@chat.test\r\nBcc: x@evil.test"}); err == nil {
+		t.Error("Send accepted a recipient with a line break")
+	}
+}
+
+func TestNewSMTPRequiresFrom(t *testing.T) {
+	if _, err := NewSMTP(SMTPConfig{Host: "localhost"}); err == nil {
+		t.Error("NewSMTP without From succeeded")
+	}
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 
+	"chat-app/mailer"
+	"chat-app/media"
 	"chat-app/models"
+	"chat-app/realtime"
 	"chat-app/routes"
+	"chat-app/search"
+	"chat-app/storage"
 )
 
 func main() {
@@ -22,20 +30,66 @@ func main() {
 	}
 
 	// 2. Auto‑migrate
+	if err := models.MigrateEmailVerified(db); err != nil {
+		log.Fatalf("Gagal migrasi verifikasi email: %v", err)
+	}
 	if err := db.AutoMigrate(
 		&models.User{},
 		&models.ChatGroup{},
 		&models.GroupMember{},
+		&models.GroupInvite{},
+		&models.GroupJoinRequest{},
 		&models.Message{},
-		&models.MessageStatus{},
+		&models.MessageRevision{},
+		&models.MessageReaction{},
+		&models.Mention{},
+		&models.Attachment{},
+		&models.AttachmentThumbnail{},
+		&models.ReadWatermark{},
+		&models.DeliveryWatermark{},
+		&models.IdempotencyKey{},
+		&models.RefreshToken{},
+		&models.Session{},
+		&models.RecoveryCode{},
+		&models.EmailToken{},
+		&models.LoginFailure{},
+		&models.RevokedToken{},
 	); err != nil {
 		log.Fatalf("Gagal migrate tabel: %v", err)
 	}
+	if err := models.BackfillGroupOwners(db); err != nil {
+		log.Fatalf("Gagal backfill owner grup: %v", err)
+	}
+	if err := models.MigrateMessageStatuses(db); err != nil {
+		log.Fatalf("Gagal migrasi status pesan: %v", err)
+	}
 	fmt.Println("Database terkoneksi & migrasi selesai.")
 
 	// 3. Setup Gin & routes
 	router := gin.Default()
-	routes.RegisterRoutes(router, db)
+	hub := realtime.NewHub()
+	go hub.Run(context.Background())
+	presence := realtime.NewPresence(db, hub, getPresenceGrace())
+	if err := presence.Reset(); err != nil {
+		log.Fatalf("Gagal reset presence: %v", err)
+	}
+	go presence.Run(context.Background())
+	store, err := storage.FromEnv()
+	if err != nil {
+		log.Fatalf("Gagal setup storage: %v", err)
+	}
+	thumbs := media.NewThumbnailer(db, store)
+	go thumbs.Run(context.Background(), 2)
+	go purgeExpired(db)
+	idx, err := search.FromEnv(db)
+	if err != nil {
+		log.Fatalf("Gagal setup search: %v", err)
+	}
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalf("Gagal setup mailer: %v", err)
+	}
+	routes.RegisterRoutes(router, db, hub, presence, store, thumbs, idx, mail)
 	router.GET("/healthcheck", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
@@ -68,3 +122,29 @@ func getDSN() string {
 	return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
 		user, pass, host, dbname)
 }
+
+// getPresenceGrace returns how long a user stays online without sending a
+// heartbeat (PRESENCE_GRACE_SECONDS, default 60).
+func getPresenceGrace() time.Duration {
+	secs, err := strconv.Atoi(os.Getenv("PRESENCE_GRACE_SECONDS"))
+	if err != nil || secs <= 0 {
+		secs = 60
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// purgeExpired deletes expired idempotency keys, tokens and login failure
+// counters once an hour.
+func purgeExpired(db *gorm.DB) {
+	for range time.Tick(time.Hour) {
+		if err := models.PurgeExpiredIdempotencyKeys(db); err != nil {
+			log.Printf("purge idempotency keys: %v", err)
+		}
+		if err := models.PurgeExpiredTokens(db); err != nil {
+			log.Printf("purge tokens: %v", err)
+		}
+		if err := models.PurgeStaleLoginFailures(db); err != nil {
+			log.Printf("purge login failures: %v", err)
+		}
+	}
+}
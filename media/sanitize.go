@@ -0,0 +1,232 @@
+// Package media post-processes uploaded images: metadata stripping and
+// thumbnail generation.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+
+	// decoders for image.Decode / image.DecodeConfig
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// maxPixels guards against decompression bombs: tiny files that decode to
+// gigantic images.
+const maxPixels = 50_000_000
+
+var ErrTooLarge = errors.New("image dimensions too large")
+
+// IsImage reports whether files of this (sniffed) MIME type are processed
+// as images.
+func IsImage(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Thumbnailable reports whether thumbnails can be made of this image.
+// golang.org/x/image/webp can't decode animated WebP, so such uploads are
+// kept (stripped of metadata) without thumbnails.
+func Thumbnailable(mimeType string, data []byte) bool {
+	return mimeType != "image/webp" || !webpAnimated(data)
+}
+
+// webpAnimated reports whether the extended header of a WebP file has its
+// animation flag set.
+func webpAnimated(data []byte) bool {
+	return len(data) >= 21 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+}
+
+// Sanitize strips metadata (EXIF with GPS position and camera details, XMP,
+// text chunks and comments) from an uploaded image and returns the cleaned bytes plus the
+// image dimensions. JPEG pixels are left untouched unless EXIF says the photo
+// is rotated; then the rotation is applied and the image re-encoded, since
+// dropping the tag would otherwise show it sideways.
+func Sanitize(mimeType string, data []byte) (out []byte, width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, 0, 0, ErrTooLarge
+	}
+
+	switch mimeType {
+	case "image/jpeg":
+		if o := jpegOrientation(data); o != 1 {
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			img = applyOrientation(img, o)
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92}); err != nil {
+				return nil, 0, 0, err
+			}
+			b := img.Bounds()
+			return buf.Bytes(), b.Dx(), b.Dy(), nil
+		}
+		out, err = stripJPEGMetadata(data)
+	case "image/png":
+		out, err = stripPNGMetadata(data)
+	case "image/webp":
+		out, err = stripWebPMetadata(data)
+	case "image/gif":
+		out, err = stripGIFMetadata(data)
+	default:
+		out = data
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return out, cfg.Width, cfg.Height, nil
+}
+
+// stripPNGMetadata drops the eXIf and text chunks of a PNG. Chunks are
+// self-contained (own CRC), so the rest is copied as-is.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(sig)) {
+		return nil, errors.New("malformed png")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(sig)
+
+	i := len(sig)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + length // length + type + data + crc
+		if length < 0 || end > len(data) {
+			return nil, errors.New("malformed png")
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+			// drop
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks of a WebP file and clears
+// their flags in the VP8X header.
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("malformed webp")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12]) // RIFF size is fixed up below
+
+	i := 12
+	for i+8 <= len(data) {
+		length := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		end := i + 8 + length + length&1 // chunks are padded to an even size
+		if length < 0 || end > len(data) {
+			return nil, errors.New("malformed webp")
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+			// drop
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if length > 0 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(b)-8))
+	return b, nil
+}
+
+// stripGIFMetadata drops the comment extensions and the application
+// extensions (XMP, ...) of a GIF, except the NETSCAPE2.0 one that makes
+// animations loop.
+func stripGIFMetadata(data []byte) ([]byte, error) {
+	errMalformed := errors.New("malformed gif")
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+
+	// header, logical screen descriptor and global color table
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, errMalformed
+	}
+	out.Write(data[:i])
+
+	// subBlocks returns the end of the data sub-blocks starting at j
+	subBlocks := func(j int) (int, error) {
+		for j < len(data) {
+			n := int(data[j])
+			j += 1 + n
+			if n == 0 {
+				return j, nil
+			}
+		}
+		return 0, errMalformed
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, errMalformed
+			}
+			end, err := subBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			keep := true
+			switch data[i+1] {
+			case 0xFE: // comment
+				keep = false
+			case 0xFF: // application
+				keep = i+14 <= len(data) && data[i+2] == 11 && string(data[i+3:i+14]) == "NETSCAPE2.0"
+			}
+			if keep {
+				out.Write(data[i:end])
+			}
+			i = end
+		case 0x2C: // image descriptor, local color table, LZW code size, data
+			j := i + 10
+			if j > len(data) {
+				return nil, errMalformed
+			}
+			if flags := data[i+9]; flags&0x80 != 0 {
+				j += 3 << (flags&0x07 + 1)
+			}
+			end, err := subBlocks(j + 1)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(data[i:end])
+			i = end
+		case 0x3B: // trailer
+			out.WriteByte(0x3B)
+			return out.Bytes(), nil
+		default:
+			return nil, errMalformed
+		}
+	}
+	return nil, errMalformed
+}
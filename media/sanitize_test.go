@@ -0,0 +1,315 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures in testdata are written by testdata/gen.go.
+
+func fixture(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// withOrientation returns a copy of an EXIF JPEG fixture with its
+// orientation tag set to o.
+func withOrientation(t *testing.T, data []byte, o uint16) []byte {
+	t.Helper()
+	out := append([]byte(nil), data...)
+	tiff := bytes.Index(out, []byte("Exif\x00\x00")) + 6
+	var order binary.ByteOrder = binary.BigEndian
+	if string(out[tiff:tiff+2]) == "II" {
+		order = binary.LittleEndian
+	}
+	tag := []byte{0, 0, 0, 0}
+	order.PutUint16(tag[0:2], 0x0112)
+	order.PutUint16(tag[2:4], 3) // SHORT
+	i := bytes.Index(out[tiff:], tag)
+	if i < 0 {
+		t.Fatal("fixture has no orientation tag")
+	}
+	order.PutUint16(out[tiff+i+8:], o)
+	return out
+}
+
+var metadataMarkers = [][]byte{
+	[]byte("Exif\x00\x00"),
+	[]byte("http://ns.adobe.com/xap"),
+	[]byte("xmpmeta"),
+	[]byte("Photoshop 3.0"),
+	[]byte("taken at home"),
+	[]byte("someone"),
+	[]byte("eXIf"),
+	[]byte("tIME"),
+}
+
+func assertNoMetadata(t *testing.T, out []byte) {
+	t.Helper()
+	for _, m := range metadataMarkers {
+		if bytes.Contains(out, m) {
+			t.Errorf("output still contains %q", m)
+		}
+	}
+}
+
+func TestSanitizeStripsMetadata(t *testing.T) {
+	tests := []struct {
+		file, mimeType string
+		width, height  int
+	}{
+		{"exif_mm.jpg", "image/jpeg", 32, 16},
+		{"exif_ii.jpg", "image/jpeg", 32, 16},
+		{"meta.png", "image/png", 32, 16},
+		{"exif.webp", "image/webp", 1, 1},
+		{"meta.gif", "image/gif", 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			out, w, h, err := Sanitize(tt.mimeType, fixture(t, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w != tt.width || h != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", w, h, tt.width, tt.height)
+			}
+			assertNoMetadata(t, out)
+
+			img, _, err := image.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("output does not decode: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("decoded size = %v", b)
+			}
+			if !Thumbnailable(tt.mimeType, out) {
+				t.Error("not thumbnailable")
+			}
+		})
+	}
+}
+
+func TestSanitizeWebPHeader(t *testing.T) {
+	out, _, _, err := Sanitize("image/webp", fixture(t, "exif.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(out[4:8]); int(got) != len(out)-8 {
+		t.Errorf("RIFF size = %d, want %d", got, len(out)-8)
+	}
+	if flags := out[20]; flags&(0x08|0x04) != 0 {
+		t.Errorf("VP8X flags = %#x, EXIF/XMP bits still set", flags)
+	}
+}
+
+func TestSanitizeGIFKeepsLoop(t *testing.T) {
+	out, _, _, err := Sanitize("image/gif", fixture(t, "meta.gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte("NETSCAPE2.0")) {
+		t.Error("loop extension dropped")
+	}
+}
+
+func TestSanitizeAnimatedWebP(t *testing.T) {
+	data := fixture(t, "anim.webp")
+	out, w, h, err := Sanitize("image/webp", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 1 || h != 1 {
+		t.Errorf("size = %dx%d", w, h)
+	}
+	assertNoMetadata(t, out)
+	for _, chunk := range []string{"ANIM", "ANMF"} {
+		if !bytes.Contains(out, []byte(chunk)) {
+			t.Errorf("%s chunk dropped", chunk)
+		}
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(out)); err != nil {
+		t.Errorf("output header does not decode: %v", err)
+	}
+	if Thumbnailable("image/webp", out) {
+		t.Error("animated WebP reported thumbnailable")
+	}
+}
+
+func TestSanitizeOrientation(t *testing.T) {
+	red, green, blue, white := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255},
+		color.RGBA{0, 0, 255, 255}, color.RGBA{255, 255, 255, 255}
+	// the stored photo is 32x16: red | green over blue | white
+	tests := []struct {
+		orientation   uint16
+		width, height int
+		topLeft       color.RGBA
+		topRight      color.RGBA
+	}{
+		{1, 32, 16, red, green},
+		{2, 32, 16, green, red},
+		{3, 32, 16, white, blue},
+		{4, 32, 16, blue, white},
+		{5, 16, 32, red, blue},
+		{6, 16, 32, blue, red},
+		{7, 16, 32, white, green},
+		{8, 16, 32, green, white},
+	}
+	for _, file := range []string{"exif_mm.jpg", "exif_ii.jpg"} {
+		for _, tt := range tests {
+			data := withOrientation(t, fixture(t, file), tt.orientation)
+			if o := jpegOrientation(data); o != int(tt.orientation) {
+				t.Fatalf("%s: jpegOrientation = %d, want %d", file, o, tt.orientation)
+			}
+
+			out, w, h, err := Sanitize("image/jpeg", data)
+			if err != nil {
+				t.Fatalf("%s orientation %d: %v", file, tt.orientation, err)
+			}
+			if w != tt.width || h != tt.height {
+				t.Errorf("%s orientation %d: size = %dx%d, want %dx%d", file, tt.orientation, w, h, tt.width, tt.height)
+			}
+			assertNoMetadata(t, out)
+
+			img, _, err := image.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("%s orientation %d: output does not decode: %v", file, tt.orientation, err)
+			}
+			// sample the middle of the top quadrants, away from blurred edges
+			b := img.Bounds()
+			y := b.Dy() / 4
+			if c := img.At(b.Dx()/4, y); !near(c, tt.topLeft) {
+				t.Errorf("%s orientation %d: top left = %v, want %v", file, tt.orientation, c, tt.topLeft)
+			}
+			if c := img.At(b.Dx()*3/4, y); !near(c, tt.topRight) {
+				t.Errorf("%s orientation %d: top right = %v, want %v", file, tt.orientation, c, tt.topRight)
+			}
+		}
+	}
+}
+
+// near compares colours with some slack for JPEG compression.
+func near(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	diff := func(got uint32, want uint8) bool {
+		d := int(got>>8) - int(want)
+		return d > -60 && d < 60
+	}
+	return diff(r, want.R) && diff(g, want.G) && diff(b, want.B)
+}
+
+func TestTiffOrientationMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":          nil,
+		"short header":   []byte("MM\x00\x2A"),
+		"bad byte order": []byte("XX\x00\x2A\x00\x00\x00\x08"),
+		"ifd past end":   []byte("MM\x00\x2A\xFF\xFF\xFF\xF0"),
+		"entries past end": []byte("MM\x00\x2A\x00\x00\x00\x08" +
+			"\xFF\xFF\x01\x12\x00\x03"),
+		"value out of range": []byte("MM\x00\x2A\x00\x00\x00\x08" +
+			"\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x09\x00\x00"),
+	}
+	for name, tiff := range tests {
+		if o := tiffOrientation(tiff); o != 1 {
+			t.Errorf("%s: orientation = %d, want 1", name, o)
+		}
+	}
+}
+
+func TestSanitizeMalformed(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		data     []byte
+	}{
+		{"image/jpeg", []byte{0xFF, 0xD8}},
+		{"image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f'}},
+		{"image/png", []byte("\x89PNG\r\n\x1a\n\xFF\xFF\xFF\xFFIHDR")},
+		{"image/webp", []byte("RIFF\x04\x00\x00\x00WEBP")},
+		{"image/webp", []byte("RIFF\x10\x00\x00\x00WEBPVP8X\x00\x00\x00\x00")},
+		{"image/gif", []byte("GIF89a\x01\x00\x01\x00\xFF\x00\x00")},
+		{"image/gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\x21\xFE\x05ab")},
+	}
+	for _, tt := range tests {
+		if _, _, _, err := Sanitize(tt.mimeType, tt.data); err == nil {
+			t.Errorf("%s %q: no error", tt.mimeType, tt.data)
+		}
+	}
+}
+
+// strippers are the format parsers Sanitize dispatches to, called directly
+// so inputs that fail image.DecodeConfig still reach them.
+var strippers = map[string]func([]byte) ([]byte, error){
+	"image/jpeg": stripJPEGMetadata,
+	"image/png":  stripPNGMetadata,
+	"image/webp": stripWebPMetadata,
+	"image/gif":  stripGIFMetadata,
+}
+
+var damageFixtures = []struct{ file, mimeType string }{
+	{"exif_mm.jpg", "image/jpeg"},
+	{"exif_ii.jpg", "image/jpeg"},
+	{"meta.png", "image/png"},
+	{"exif.webp", "image/webp"},
+	{"anim.webp", "image/webp"},
+	{"meta.gif", "image/gif"},
+}
+
+// checkDamaged runs a damaged input through every parser. They must not
+// panic, and whatever Sanitize accepts must still decode.
+func checkDamaged(t *testing.T, mimeType string, data []byte) {
+	t.Helper()
+	jpegOrientation(data)
+	strippers[mimeType](data)
+
+	out, _, _, err := Sanitize(mimeType, data)
+	if err != nil {
+		return
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(out)); err != nil {
+		t.Errorf("%s: accepted %x but the output does not decode: %v", mimeType, data, err)
+	}
+}
+
+func TestSanitizeTruncated(t *testing.T) {
+	for _, f := range damageFixtures {
+		data := fixture(t, f.file)
+		for n := 0; n < len(data); n++ {
+			checkDamaged(t, f.mimeType, data[:n])
+		}
+	}
+}
+
+func TestSanitizeCorrupted(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, f := range damageFixtures {
+		data := fixture(t, f.file)
+		for round := 0; round < 2000; round++ {
+			bad := append([]byte(nil), data...)
+			for k := 1 + rng.Intn(4); k > 0; k-- {
+				bad[rng.Intn(len(bad))] = byte(rng.Intn(256))
+			}
+			checkDamaged(t, f.mimeType, bad)
+		}
+	}
+}
+
+func FuzzSanitize(f *testing.F) {
+	for _, fx := range damageFixtures {
+		f.Add(fx.mimeType, fixture(f, fx.file))
+	}
+	f.Fuzz(func(t *testing.T, mimeType string, data []byte) {
+		if strippers[mimeType] == nil {
+			return
+		}
+		checkDamaged(t, mimeType, data)
+	})
+}
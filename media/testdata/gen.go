@@ -0,0 +1,214 @@
+//go:build ignore
+
+// gen writes the image fixtures of the media tests: small images carrying
+// the metadata blocks Sanitize must strip. Run it from this directory with
+// "go run gen.go".
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+)
+
+// quadrants are the colours of the top-left, top-right, bottom-left and
+// bottom-right quarter of the test photo.
+var quadrants = [4]color.RGBA{
+	{255, 0, 0, 255},
+	{0, 255, 0, 255},
+	{0, 0, 255, 255},
+	{255, 255, 255, 255},
+}
+
+func main() {
+	write("exif_mm.jpg", exifJPEG(binary.BigEndian, "MM"))
+	write("exif_ii.jpg", exifJPEG(binary.LittleEndian, "II"))
+	write("meta.png", metaPNG())
+	write("exif.webp", metaWebP(false))
+	write("anim.webp", metaWebP(true))
+	write("meta.gif", metaGIF())
+}
+
+func write(name string, data []byte) {
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// photo is 32x16 with a solid colour per quadrant, so every orientation
+// can be told apart.
+func photo() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetRGBA(x, y, quadrants[(y/8)*2+x/16])
+		}
+	}
+	return img
+}
+
+// exifTIFF is an EXIF block whose IFD0 has an orientation of 1 (patched by
+// the tests), a camera model and a GPS IFD with a latitude reference.
+func exifTIFF(order binary.ByteOrder, mark string) []byte {
+	var b bytes.Buffer
+	b.WriteString(mark)
+	binary.Write(&b, order, uint16(42))
+	binary.Write(&b, order, uint32(8)) // IFD0
+
+	entry := func(tag, typ uint16, count uint32, value [4]byte) {
+		binary.Write(&b, order, tag)
+		binary.Write(&b, order, typ)
+		binary.Write(&b, order, count)
+		b.Write(value[:])
+	}
+	short := func(v uint16) (out [4]byte) {
+		order.PutUint16(out[:2], v)
+		return
+	}
+	long := func(v uint32) (out [4]byte) {
+		order.PutUint32(out[:], v)
+		return
+	}
+
+	// IFD0 at 8: 3 entries, then the next-IFD offset
+	const gpsIFD = 8 + 2 + 3*12 + 4
+	binary.Write(&b, order, uint16(3))
+	entry(0x0110, 2, 4, [4]byte{'C', 'a', 'm', 0}) // Model
+	entry(0x0112, 3, 1, short(1))                  // Orientation
+	entry(0x8825, 4, 1, long(gpsIFD))              // GPS IFD
+	binary.Write(&b, order, uint32(0))
+
+	binary.Write(&b, order, uint16(1))
+	entry(0x0001, 2, 2, [4]byte{'N', 0}) // GPSLatitudeRef
+	binary.Write(&b, order, uint32(0))
+	return b.Bytes()
+}
+
+func segment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func exifJPEG(order binary.ByteOrder, mark string) []byte {
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, photo(), &jpeg.Options{Quality: 100}); err != nil {
+		log.Fatal(err)
+	}
+	base := enc.Bytes()
+
+	var out bytes.Buffer
+	out.Write(base[:2]) // SOI
+	out.Write(segment(0xE1, append([]byte("Exif\x00\x00"), exifTIFF(order, mark)...)))
+	out.Write(segment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")))
+	out.Write(segment(0xED, []byte("Photoshop 3.0\x008BIM")))
+	out.Write(segment(0xFE, []byte("taken at home")))
+	out.Write(base[2:])
+	return out.Bytes()
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(len(data)))
+	b.WriteString(typ)
+	b.Write(data)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+	return b.Bytes()
+}
+
+func metaPNG() []byte {
+	var enc bytes.Buffer
+	if err := png.Encode(&enc, photo()); err != nil {
+		log.Fatal(err)
+	}
+	base := enc.Bytes()
+	idat := bytes.Index(base, []byte("IDAT")) - 4
+
+	var out bytes.Buffer
+	out.Write(base[:idat])
+	out.Write(pngChunk("tEXt", []byte("Author\x00someone")))
+	out.Write(pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>")))
+	out.Write(pngChunk("eXIf", exifTIFF(binary.BigEndian, "MM")))
+	out.Write(pngChunk("tIME", []byte{0x07, 0xE8, 1, 2, 3, 4, 5}))
+	out.Write(base[idat:])
+	return out.Bytes()
+}
+
+func riffChunk(fourCC string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(fourCC)
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	if len(data)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// metaWebP is a 1x1 lossless WebP (or a one-frame animation of it) in an
+// extended container with EXIF and XMP chunks.
+func metaWebP(animated bool) []byte {
+	// the VP8L bitstream of a 1x1 image
+	vp8l, _ := base64.StdEncoding.DecodeString("LwAAABAHEBERiIj+BwA=")
+
+	flags := byte(0x08 | 0x04) // EXIF, XMP
+	if animated {
+		flags |= 0x02
+	}
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	body.Write(riffChunk("VP8X", []byte{flags, 0, 0, 0, 0, 0, 0, 0, 0, 0}))
+	if animated {
+		body.Write(riffChunk("ANIM", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0}))
+		frame := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0}
+		body.Write(riffChunk("ANMF", append(frame, riffChunk("VP8L", vp8l)...)))
+	} else {
+		body.Write(riffChunk("VP8L", vp8l))
+	}
+	body.Write(riffChunk("EXIF", exifTIFF(binary.LittleEndian, "II")[:45])) // odd size: padded
+	body.Write(riffChunk("XMP ", []byte("<x:xmpmeta/>")))
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// metaGIF is a looping two-frame animation with a comment and an XMP
+// application extension.
+func metaGIF() []byte {
+	frame := func(c color.Color) *image.Paletted {
+		img := image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)
+		for i := range img.Pix {
+			img.Pix[i] = uint8(img.Palette.Index(c))
+		}
+		return img
+	}
+	var enc bytes.Buffer
+	err := gif.EncodeAll(&enc, &gif.GIF{
+		Image: []*image.Paletted{frame(quadrants[0]), frame(quadrants[2])},
+		Delay: []int{10, 10},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	base := enc.Bytes()
+	netscape := bytes.Index(base, []byte("\x21\xFF\x0BNETSCAPE2.0")) // after the header
+
+	var out bytes.Buffer
+	out.Write(base[:netscape])
+	out.Write([]byte("\x21\xFE\x0Dtaken at home\x00"))
+	out.Write([]byte("\x21\xFF\x0BXMP DataXMP\x0C<x:xmpmeta/>\x00"))
+	out.Write(base[netscape:])
+	return out.Bytes()
+}
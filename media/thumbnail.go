@@ -0,0 +1,166 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"path"
+
+	"golang.org/x/image/draw"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chat-app/models"
+	"chat-app/storage"
+)
+
+// ThumbnailSizes maps size names to the longest edge in pixels.
+var ThumbnailSizes = []struct {
+	Name string
+	Edge int
+}{
+	{"small", 160},
+	{"medium", 480},
+	{"large", 1080},
+}
+
+// Thumbnailer generates thumbnails for image attachments in the background.
+type Thumbnailer struct {
+	DB      *gorm.DB
+	Storage storage.Storage
+	jobs    chan string
+}
+
+func NewThumbnailer(db *gorm.DB, store storage.Storage) *Thumbnailer {
+	return &Thumbnailer{DB: db, Storage: store, jobs: make(chan string, 256)}
+}
+
+// Enqueue schedules thumbnails for an attachment. If the queue is full the
+// attachment stays pending and is picked up again on the next start.
+func (t *Thumbnailer) Enqueue(attachmentID string) {
+	select {
+	case t.jobs <- attachmentID:
+	default:
+		log.Printf("thumbnail queue full, deferring %s", attachmentID)
+	}
+}
+
+// Run starts workers that process the queue until ctx is cancelled.
+// Attachments left pending by a previous run are queued again first.
+func (t *Thumbnailer) Run(ctx context.Context, workers int) {
+	var pending []string
+	t.DB.Model(&models.Attachment{}).
+		Where("thumbnail_status = ?", models.ThumbnailPending).
+		Pluck("id", &pending)
+	go func() {
+		for _, id := range pending {
+			select {
+			case t.jobs <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-t.jobs:
+					if err := t.process(ctx, id); err != nil {
+						log.Printf("thumbnails for %s failed: %v", id, err)
+						t.DB.Model(&models.Attachment{}).Where("id = ?", id).
+							Update("thumbnail_status", models.ThumbnailFailed)
+					}
+				}
+			}
+		}()
+	}
+}
+
+func (t *Thumbnailer) process(ctx context.Context, id string) error {
+	var att models.Attachment
+	if err := t.DB.First(&att, "id = ?", id).Error; err != nil {
+		return err
+	}
+	if att.ThumbnailStatus != models.ThumbnailPending {
+		return nil
+	}
+
+	rc, err := t.Storage.Open(ctx, att.StorageKey)
+	if err != nil {
+		return err
+	}
+	// originals were already stripped and rotated upright on upload
+	img, _, err := image.Decode(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	b := img.Bounds()
+	for i, size := range ThumbnailSizes {
+		// no point in "thumbnails" bigger than the original, but always
+		// keep the smallest one so lists have something to show
+		if i > 0 && b.Dx() <= size.Edge && b.Dy() <= size.Edge {
+			break
+		}
+		thumb := resize(img, size.Edge)
+
+		var buf bytes.Buffer
+		mimeType, ext := "image/jpeg", ".jpg"
+		if att.MimeType == "image/jpeg" {
+			err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		} else {
+			// keep transparency
+			mimeType, ext = "image/png", ".png"
+			err = png.Encode(&buf, thumb)
+		}
+		if err != nil {
+			return err
+		}
+
+		key := path.Join("thumbnails", att.ID, size.Name+ext)
+		if err := t.Storage.Put(ctx, key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), mimeType); err != nil {
+			return err
+		}
+		tb := thumb.Bounds()
+		if err := t.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.AttachmentThumbnail{
+			AttachmentID: att.ID,
+			Size:         size.Name,
+			Width:        tb.Dx(),
+			Height:       tb.Dy(),
+			MimeType:     mimeType,
+			StorageKey:   key,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	return t.DB.Model(&att).Update("thumbnail_status", models.ThumbnailReady).Error
+}
+
+// resize scales img so its longest edge is at most edge, keeping the aspect
+// ratio. Smaller images are returned unchanged.
+func resize(img image.Image, edge int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= edge && h <= edge {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*edge/w)
+		w = edge
+	} else {
+		w = max(1, w*edge/h)
+		h = edge
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Attachment is a file uploaded by a user. It is uploaded on its own first
+// (MessageID nil) and linked to a message when that message is sent.
+type Attachment struct {
+    ID         string    `gorm:"type:char(36);primaryKey"`
+    MessageID  *string   `gorm:"type:char(36);index"` // nullable until sent with a message
+    UploaderID string    `gorm:"type:char(36);not null;index"`
+    FileName   string    `gorm:"type:varchar(255);not null"`
+    MimeType   string    `gorm:"type:varchar(100);not null"` // sniffed from the content, not the client's header
+    Size       int64     `gorm:"not null"`
+    Width      int       `gorm:"not null;default:0"` // images only
+    Height     int       `gorm:"not null;default:0"` // images only
+    StorageKey string    `gorm:"type:varchar(255);not null" json:"-"`
+    CreatedAt  time.Time `gorm:"autoCreateTime"`
+
+    // "" for non-images, otherwise pending → ready / failed
+    ThumbnailStatus string                `gorm:"type:varchar(10);not null;default:''"`
+    Thumbnails      []AttachmentThumbnail `gorm:"foreignKey:AttachmentID"`
+
+    // URL is the authorized download endpoint for this file
+    URL string `gorm:"-"`
+}
+
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+    a.URL = "/api/attachments/" + a.ID + "/download"
+    return nil
+}
+
+const (
+    ThumbnailPending = "pending"
+    ThumbnailReady   = "ready"
+    ThumbnailFailed  = "failed"
+)
+
+// AttachmentThumbnail is a scaled-down copy of an image attachment, stored
+// next to the original.
+type AttachmentThumbnail struct {
+    AttachmentID string `gorm:"type:char(36);primaryKey"`
+    Size         string `gorm:"type:varchar(10);primaryKey"` // small, medium, large
+    Width        int    `gorm:"not null"`
+    Height       int    `gorm:"not null"`
+    MimeType     string `gorm:"type:varchar(100);not null"`
+    StorageKey   string `gorm:"type:varchar(255);not null" json:"-"`
+
+    URL string `gorm:"-"`
+}
+
+func (t *AttachmentThumbnail) AfterFind(tx *gorm.DB) error {
+    t.URL = "/api/attachments/" + t.AttachmentID + "/thumbnails/" + t.Size
+    return nil
+}
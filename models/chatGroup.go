@@ -7,12 +7,23 @@ import (
 )
 
 type ChatGroup struct {
-    ID        string         `gorm:"type:char(36);primaryKey"`
-    Name      string         `gorm:"type:varchar(100);not null"`
-    CreatedBy string         `gorm:"type:char(36);not null"`
+    ID         string         `gorm:"type:char(36);primaryKey"`
+    Name       string         `gorm:"type:varchar(100);not null"`
+    Visibility string         `gorm:"type:varchar(20);not null;default:'public'"`
+    CreatedBy  string         `gorm:"type:char(36);not null"`
     CreatedAt time.Time      `gorm:"autoCreateTime"`
     UpdatedAt time.Time      `gorm:"autoUpdateTime"`
     DeletedAt gorm.DeletedAt `gorm:"index"`
 
     Members []GroupMember `gorm:"foreignKey:GroupID"`
 }
+
+// Group visibility.
+//   - public: listed for everyone, anyone can join directly
+//   - private: listed for everyone, joining creates a request admins approve
+//   - invite_only: hidden from non-members, joinable only with an invite link
+const (
+    GroupPublic     = "public"
+    GroupPrivate    = "private"
+    GroupInviteOnly = "invite_only"
+)
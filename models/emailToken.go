@@ -0,0 +1,23 @@
+package models
+
+import (
+    "time"
+)
+
+// EmailToken records a signed token mailed to a user (password reset,
+// email verification) so it can be used only once. The token itself is a
+// JWT whose ID is JTI; it is never stored.
+type EmailToken struct {
+    JTI       string     `gorm:"type:char(36);primaryKey"`
+    UserID    string     `gorm:"type:char(36);not null;index:idx_email_token_user_purpose"`
+    Purpose   string     `gorm:"type:varchar(20);not null;index:idx_email_token_user_purpose"`
+    ExpiresAt time.Time  `gorm:"not null;index"`
+    UsedAt    *time.Time `gorm:""`
+    CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+// Email token purposes.
+const (
+    TokenPasswordReset = "password_reset"
+    TokenVerifyEmail   = "verify_email"
+)
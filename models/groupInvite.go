@@ -0,0 +1,19 @@
+package models
+
+import (
+    "time"
+)
+
+// GroupInvite is an invite link token generated by a group admin. It can
+// expire and be limited to a number of uses.
+type GroupInvite struct {
+    ID        string     `gorm:"type:char(36);primaryKey"`
+    GroupID   string     `gorm:"type:char(36);not null;index"`
+    Token     string     `gorm:"type:varchar(64);not null;uniqueIndex"`
+    CreatedBy string     `gorm:"type:char(36);not null"`
+    MaxUses   int        `gorm:"not null;default:0"` // 0 = unlimited
+    Uses      int        `gorm:"not null;default:0"`
+    ExpiresAt *time.Time `gorm:""` // nil = never
+    RevokedAt *time.Time `gorm:""`
+    CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
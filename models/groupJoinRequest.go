@@ -0,0 +1,25 @@
+package models
+
+import (
+    "time"
+)
+
+// GroupJoinRequest is a request to join a private group, waiting for an
+// admin to approve or reject it.
+type GroupJoinRequest struct {
+    ID        string     `gorm:"type:char(36);primaryKey"`
+    GroupID   string     `gorm:"type:char(36);not null;index"`
+    UserID    string     `gorm:"type:char(36);not null;index"`
+    Status    string     `gorm:"type:varchar(10);not null;default:'pending'"`
+    DecidedBy *string    `gorm:"type:char(36)"`
+    DecidedAt *time.Time `gorm:""`
+    CreatedAt time.Time  `gorm:"autoCreateTime"`
+
+    User User `gorm:"foreignKey:UserID"`
+}
+
+const (
+    JoinRequestPending  = "pending"
+    JoinRequestApproved = "approved"
+    JoinRequestRejected = "rejected"
+)
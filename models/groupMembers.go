@@ -9,8 +9,42 @@ import (
 type GroupMember struct {
     GroupID  string         `gorm:"type:char(36);primaryKey"`
     UserID   string         `gorm:"type:char(36);primaryKey"`
+    Role     string         `gorm:"type:varchar(10);not null;default:'member'"`
     JoinedAt time.Time      `gorm:"autoCreateTime"`
     DeletedAt gorm.DeletedAt `gorm:"index"`
 
     User  User      `gorm:"foreignKey:UserID"`
 }
+
+// Group roles, from most to least privileged. Every group has exactly one
+// owner; admins moderate; members just chat.
+const (
+    GroupRoleOwner  = "owner"
+    GroupRoleAdmin  = "admin"
+    GroupRoleMember = "member"
+)
+
+// GroupRoleRank orders roles so they can be compared (higher is stronger).
+func GroupRoleRank(role string) int {
+    switch role {
+    case GroupRoleOwner:
+        return 3
+    case GroupRoleAdmin:
+        return 2
+    case GroupRoleMember:
+        return 1
+    default:
+        return 0
+    }
+}
+
+// BackfillGroupOwners makes the creator the owner of every group that has no
+// owner yet (groups created before roles existed).
+func BackfillGroupOwners(db *gorm.DB) error {
+    return db.Exec(`
+        UPDATE group_members SET role = ?
+        WHERE deleted_at IS NULL
+          AND EXISTS (SELECT 1 FROM chat_groups g WHERE g.id = group_members.group_id AND g.created_by = group_members.user_id)
+          AND group_id NOT IN (SELECT group_id FROM (SELECT group_id FROM group_members WHERE role = ? AND deleted_at IS NULL) AS owned)`,
+        GroupRoleOwner, GroupRoleOwner).Error
+}
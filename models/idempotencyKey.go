@@ -0,0 +1,23 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// IdempotencyKey remembers which message a client-supplied key created, so a
+// retried POST /api/messages returns the original message instead of
+// sending it twice. Keys are per sender and can be reused once expired.
+type IdempotencyKey struct {
+    SenderID  string    `gorm:"type:char(36);primaryKey"`
+    Key       string    `gorm:"type:varchar(100);primaryKey"`
+    MessageID string    `gorm:"type:char(36);not null"`
+    CreatedAt time.Time `gorm:"autoCreateTime"`
+    ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// PurgeExpiredIdempotencyKeys deletes keys whose window has passed.
+func PurgeExpiredIdempotencyKeys(db *gorm.DB) error {
+    return db.Where("expires_at <= ?", time.Now()).Delete(&IdempotencyKey{}).Error
+}
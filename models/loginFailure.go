@@ -0,0 +1,36 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// LoginFailure counts recent failed logins for one email address or one
+// client IP, whether or not an account with that email exists. Attempts are
+// counted before the credentials are checked and taken back when they were
+// right, so LastFailedAt is really the time of the last counted attempt.
+type LoginFailure struct {
+    Scope        string     `gorm:"type:varchar(10);primaryKey"`  // LoginScopeEmail or LoginScopeIP
+    Subject      string     `gorm:"type:varchar(255);primaryKey"` // lower-cased email or IP
+    Failures     int        `gorm:"not null;default:0"`
+    LastFailedAt time.Time  `gorm:"not null;index"`
+    LockedUntil  *time.Time `gorm:""`
+}
+
+const (
+    LoginScopeEmail = "email"
+    LoginScopeIP    = "ip"
+)
+
+// LoginFailureWindow is how long a failed login is remembered: the count
+// starts over after this long without failures.
+const LoginFailureWindow = time.Hour
+
+// PurgeStaleLoginFailures deletes counters that are past the window and not
+// holding a lockout.
+func PurgeStaleLoginFailures(db *gorm.DB) error {
+    now := time.Now()
+    return db.Where("last_failed_at <= ? AND (locked_until IS NULL OR locked_until <= ?)", now.Add(-LoginFailureWindow), now).
+        Delete(&LoginFailure{}).Error
+}
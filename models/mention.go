@@ -0,0 +1,25 @@
+package models
+
+import (
+    "time"
+)
+
+// Mention records that a message mentioned a user, either by @username or
+// through @all / @here in a group.
+type Mention struct {
+    ID        string     `gorm:"type:char(36);primaryKey"`
+    MessageID string     `gorm:"type:char(36);not null;uniqueIndex:idx_mention_msg_user"`
+    UserID    string     `gorm:"type:char(36);not null;uniqueIndex:idx_mention_msg_user;index"`
+    Kind      string     `gorm:"type:varchar(10);not null"` // user, all, here
+    IsRead    bool       `gorm:"default:false"`
+    ReadAt    *time.Time `gorm:""`
+    CreatedAt time.Time  `gorm:"autoCreateTime"`
+
+    Message Message `gorm:"foreignKey:MessageID"`
+}
+
+const (
+    MentionUser = "user"
+    MentionAll  = "all"
+    MentionHere = "here"
+)
@@ -7,15 +7,29 @@ import (
 )
 
 type Message struct {
-    ID         string         `gorm:"type:char(36);primaryKey"`
-    SenderID   string         `gorm:"type:char(36);not null"`
-    GroupID    *string        `gorm:"type:char(36);"`       // nullable: pesan ke grup
-    ReceiverID *string        `gorm:"type:char(36);"`       // nullable: pesan ke user (1-on-1)
-    Content    string         `gorm:"type:text;not null"`
-    SentAt     time.Time      `gorm:"autoCreateTime"`
-    DeletedAt  gorm.DeletedAt `gorm:"index"`
+    ID           string         `gorm:"type:char(36);primaryKey"`
+    SenderID     string         `gorm:"type:char(36);not null"`
+    GroupID      *string        `gorm:"type:char(36);"`       // nullable: pesan ke grup
+    ReceiverID   *string        `gorm:"type:char(36);"`       // nullable: pesan ke user (1-on-1)
+    ParentID     *string        `gorm:"type:char(36);"`       // nullable: message this one replies to
+    ThreadRootID *string        `gorm:"type:char(36);index"`  // nullable: top-level message of the thread
+    Content      string         `gorm:"type:text;not null"` // FULLTEXT-indexed by search.NewMySQL
+    SentAt       time.Time      `gorm:"autoCreateTime"`
+    EditedAt     *time.Time     `gorm:""`                    // nullable: set on every edit
+    DeletedAt    gorm.DeletedAt `gorm:"index"`
 
-    Sender   User       `gorm:"foreignKey:SenderID"`
-    Group    ChatGroup  `gorm:"foreignKey:GroupID"`
-    Receiver User       `gorm:"foreignKey:ReceiverID"`
+    // thread stats, only maintained on thread roots
+    ReplyCount  int        `gorm:"not null;default:0"`
+    LastReplyAt *time.Time `gorm:""`
+    // replies in this thread the current user hasn't read; filled per request
+    UnreadReplies int64 `gorm:"-"`
+    // aggregated emoji reactions; filled per request
+    Reactions []ReactionSummary `gorm:"-"`
+    // delivered / read counts, only on the caller's own messages; filled per request
+    Receipts *ReceiptSummary `gorm:"-"`
+
+    Sender      User         `gorm:"foreignKey:SenderID"`
+    Group       ChatGroup    `gorm:"foreignKey:GroupID"`
+    Receiver    User         `gorm:"foreignKey:ReceiverID"`
+    Attachments []Attachment `gorm:"foreignKey:MessageID"`
 }
@@ -0,0 +1,14 @@
+package models
+
+import (
+    "time"
+)
+
+// MessageRevision keeps the content a message had before an edit.
+type MessageRevision struct {
+    ID        string    `gorm:"type:char(36);primaryKey"`
+    MessageID string    `gorm:"type:char(36);not null;index"`
+    Content   string    `gorm:"type:text;not null"`
+    EditedBy  string    `gorm:"type:char(36);not null"`
+    EditedAt  time.Time `gorm:"autoCreateTime"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+    "time"
+)
+
+// MessageReaction is one user's emoji reaction on a message. A user can put
+// several different emojis on the same message, but each only once.
+type MessageReaction struct {
+    MessageID string    `gorm:"type:char(36);primaryKey"`
+    UserID    string    `gorm:"type:char(36);primaryKey"`
+    Emoji     string    `gorm:"type:varchar(32);primaryKey"`
+    CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// ReactionSummary aggregates the reactions of one emoji on a message.
+// Reacted tells whether the current user is one of them.
+type ReactionSummary struct {
+    Emoji   string
+    Count   int64
+    Reacted bool
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// ReadWatermark is how far a user has read a conversation: every message up
+// to and including LastReadMessageID (ordered by sent_at, id) is read.
+//
+// ConversationKey is one of
+//   - "group:<groupID>"   top-level messages of a group
+//   - "dm:<otherUserID>"  top-level messages of a 1‑on‑1 conversation
+//   - "thread:<rootID>"   replies in a thread
+type ReadWatermark struct {
+    UserID            string    `gorm:"type:char(36);primaryKey"`
+    ConversationKey   string    `gorm:"type:varchar(50);primaryKey"`
+    LastReadMessageID string    `gorm:"type:char(36);not null"`
+    LastReadAt        time.Time `gorm:"not null"` // sent_at of LastReadMessageID
+    UpdatedAt         time.Time `gorm:"autoUpdateTime"`
+}
+
+// DeliveryWatermark is how far a user's clients have received a
+// conversation, keyed like ReadWatermark. Reading implies delivery, so it is
+// never behind the read watermark.
+type DeliveryWatermark struct {
+    UserID                 string    `gorm:"type:char(36);primaryKey"`
+    ConversationKey        string    `gorm:"type:varchar(50);primaryKey"`
+    LastDeliveredMessageID string    `gorm:"type:char(36);not null"`
+    LastDeliveredAt        time.Time `gorm:"not null"` // sent_at of LastDeliveredMessageID
+    UpdatedAt              time.Time `gorm:"autoUpdateTime"`
+}
+
+// Receipt states of a message for one recipient.
+const (
+    ReceiptSent      = "sent"
+    ReceiptDelivered = "delivered"
+    ReceiptRead      = "read"
+)
+
+// ReceiptSummary aggregates the receipts of a message: delivered to N and
+// read by N of Recipients.
+type ReceiptSummary struct {
+    Recipients int64 `json:"recipients"`
+    Delivered  int64 `json:"delivered"`
+    Read       int64 `json:"read"`
+}
+
+// ConversationKey returns the watermark key of the conversation (or thread)
+// msg belongs to, as seen by userID.
+func ConversationKey(msg *Message, userID string) string {
+    switch {
+    case msg.ThreadRootID != nil:
+        return "thread:" + *msg.ThreadRootID
+    case msg.GroupID != nil:
+        return "group:" + *msg.GroupID
+    case msg.SenderID != userID:
+        return "dm:" + msg.SenderID
+    case msg.ReceiverID != nil:
+        return "dm:" + *msg.ReceiverID
+    default:
+        return ""
+    }
+}
+
+// MigrateMessageStatuses converts the per-message read flags of the old
+// message_statuses table into watermarks: the newest message a user had read
+// in a conversation becomes their watermark there. The old table is renamed
+// to message_statuses_legacy afterwards, so this runs once.
+func MigrateMessageStatuses(db *gorm.DB) error {
+    if !db.Migrator().HasTable("message_statuses") {
+        return nil
+    }
+
+    rows, err := db.Raw(`
+        SELECT s.user_id, m.id, m.sent_at, m.sender_id, m.receiver_id, m.group_id, m.thread_root_id
+        FROM message_statuses s
+        JOIN messages m ON m.id = s.message_id
+        WHERE s.is_read = ? AND s.deleted_at IS NULL`, true).Rows()
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    marks := make(map[[2]string]*ReadWatermark)
+    for rows.Next() {
+        var userID string
+        var msg Message
+        if err := rows.Scan(&userID, &msg.ID, &msg.SentAt, &msg.SenderID, &msg.ReceiverID, &msg.GroupID, &msg.ThreadRootID); err != nil {
+            return err
+        }
+        key := ConversationKey(&msg, userID)
+        if key == "" {
+            continue
+        }
+        wm := marks[[2]string{userID, key}]
+        if wm == nil || msg.SentAt.After(wm.LastReadAt) ||
+            (msg.SentAt.Equal(wm.LastReadAt) && msg.ID > wm.LastReadMessageID) {
+            marks[[2]string{userID, key}] = &ReadWatermark{
+                UserID:            userID,
+                ConversationKey:   key,
+                LastReadMessageID: msg.ID,
+                LastReadAt:        msg.SentAt,
+            }
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    list := make([]*ReadWatermark, 0, len(marks))
+    for _, wm := range marks {
+        list = append(list, wm)
+    }
+    return db.Transaction(func(tx *gorm.DB) error {
+        if len(list) > 0 {
+            // existing watermarks are newer than anything in the old table
+            if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(list, 500).Error; err != nil {
+                return err
+            }
+        }
+        return tx.Migrator().RenameTable("message_statuses", "message_statuses_legacy")
+    })
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// authenticator is lost. Only a SHA-256 of the code is stored.
+type RecoveryCode struct {
+    ID        string     `gorm:"type:char(36);primaryKey"`
+    UserID    string     `gorm:"type:char(36);not null;index"`
+    CodeHash  string     `gorm:"type:char(64);not null;uniqueIndex"`
+    UsedAt    *time.Time `gorm:""`
+    CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
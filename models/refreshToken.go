@@ -0,0 +1,47 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// RefreshToken is one link in a chain of rotating refresh tokens. Every
+// login starts a new family (its Session); each refresh marks the presented
+// token used and issues its successor in the same family. Only a SHA-256 of
+// the token is stored.
+type RefreshToken struct {
+    ID              string     `gorm:"type:char(36);primaryKey"`
+    FamilyID        string     `gorm:"type:char(36);not null;index"`
+    UserID          string     `gorm:"type:char(36);not null;index"`
+    TokenHash       string     `gorm:"type:char(64);not null;uniqueIndex"`
+    AccessJTI       string     `gorm:"type:char(36);not null;index"` // access token issued together with this one
+    AccessExpiresAt time.Time  `gorm:"not null"`
+    ExpiresAt       time.Time  `gorm:"not null;index"`
+    UsedAt          *time.Time `gorm:""` // set when rotated
+    RevokedAt       *time.Time `gorm:""`
+    CreatedAt       time.Time  `gorm:"autoCreateTime"`
+}
+
+// RevokedToken lists access tokens (by jti) that must no longer be
+// accepted, until they would have expired anyway.
+type RevokedToken struct {
+    JTI       string    `gorm:"type:char(36);primaryKey"`
+    ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// PurgeExpiredTokens deletes refresh tokens, sessions, revocation entries
+// and emailed tokens that are past their expiry and can't be used any more.
+func PurgeExpiredTokens(db *gorm.DB) error {
+    now := time.Now()
+    if err := db.Where("expires_at <= ?", now).Delete(&RefreshToken{}).Error; err != nil {
+        return err
+    }
+    if err := db.Where("expires_at <= ?", now).Delete(&Session{}).Error; err != nil {
+        return err
+    }
+    if err := db.Where("expires_at <= ?", now).Delete(&EmailToken{}).Error; err != nil {
+        return err
+    }
+    return db.Where("expires_at <= ?", now).Delete(&RevokedToken{}).Error
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Session is one login on one device. Its ID is the family ID of the login's
+// refresh tokens and the "sid" claim of its access tokens.
+type Session struct {
+    ID         string     `gorm:"type:char(36);primaryKey"`
+    UserID     string     `gorm:"type:char(36);not null;index"`
+    DeviceName string     `gorm:"type:varchar(100);not null;default:''"`
+    IP         string     `gorm:"type:varchar(45);not null;default:''"`
+    UserAgent  string     `gorm:"type:varchar(255);not null;default:''"`
+    CreatedAt  time.Time  `gorm:"autoCreateTime"`
+    LastUsedAt time.Time  `gorm:"not null"`
+    ExpiresAt  time.Time  `gorm:"not null;index"` // expiry of its current refresh token
+    RevokedAt  *time.Time `gorm:""`
+}
@@ -7,13 +7,40 @@ import (
 )
 
 type User struct {
-	ID        string         `gorm:"type:char(36);primaryKey"`
-	Username  string         `gorm:"type:varchar(50);not null;unique"`
-	Email     string         `gorm:"type:varchar(100);not null;unique"`
-	Password  string         `gorm:"type:varchar(255);not null"`
-	IsOnline  bool           `gorm:"type:boolean;not null;default:false"`
-	LastSeen  *time.Time     `gorm:""`
-	CreatedAt time.Time      `gorm:"autoCreateTime"`
-	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
-	DeletedAt gorm.DeletedAt `gorm:"index"` // soft delete
+	ID              string         `gorm:"type:char(36);primaryKey"`
+	Username        string         `gorm:"type:varchar(50);not null;unique"`
+	Email           string         `gorm:"type:varchar(100);not null;unique"`
+	Password        string         `gorm:"type:varchar(255);not null"`
+	Role            string         `gorm:"type:varchar(20);not null;default:'user'"`
+	IsOnline        bool           `gorm:"type:boolean;not null;default:false"`
+	LastSeen        *time.Time     `gorm:""`
+	EmailVerifiedAt *time.Time     `gorm:""` // nil until Email is verified; reset when it changes
+	TOTPSecret      string         `gorm:"type:varchar(64);not null;default:''" json:"-"` // set on 2FA setup, active once TOTPEnabled
+	TOTPEnabled     bool           `gorm:"not null;default:false"`
+	TOTPLastStep    int64          `gorm:"not null;default:0" json:"-"` // last accepted time step, so a code works only once
+	CreatedAt       time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt       gorm.DeletedAt `gorm:"index"` // soft delete
+}
+
+// User roles. Admins may manage every account.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// MigrateEmailVerified adds the email_verified_at column and marks every
+// existing account verified, so turning on REQUIRE_VERIFIED_EMAIL only
+// affects accounts registered afterwards. It does nothing once the column
+// exists; call it before AutoMigrate.
+func MigrateEmailVerified(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&User{}) || m.HasColumn(&User{}, "EmailVerifiedAt") {
+		return nil
+	}
+	if err := m.AddColumn(&User{}, "EmailVerifiedAt"); err != nil {
+		return err
+	}
+	return db.Model(&User{}).Unscoped().Where("email_verified_at IS NULL").
+		UpdateColumn("email_verified_at", gorm.Expr("created_at")).Error
 }
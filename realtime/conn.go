@@ -0,0 +1,90 @@
+package realtime
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// time allowed to write a frame to the peer
+	writeWait = 10 * time.Second
+	// time allowed to read the next pong from the peer
+	pongWait = 60 * time.Second
+	// pings are sent with this period; must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// largest frame accepted from a client
+	maxMessageSize = 4096
+)
+
+// Inbound is a frame sent by the client over its socket.
+type Inbound struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Serve pumps events from the hub to conn until the peer goes away.
+// Frames received from the peer are decoded and passed to onMessage (which
+// may be nil). Serve blocks until the connection is closed and always
+// unregisters the client before returning.
+func Serve(hub *Hub, conn *websocket.Conn, client *Client, onMessage func(Inbound)) {
+	done := make(chan struct{})
+	go writePump(conn, client, done)
+
+	defer func() {
+		hub.Unregister(client)
+		<-done
+	}()
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var in Inbound
+		if err := json.Unmarshal(data, &in); err != nil {
+			// ignore malformed frames instead of dropping the connection
+			continue
+		}
+		if onMessage != nil {
+			onMessage(in)
+		}
+	}
+}
+
+func writePump(conn *websocket.Conn, client *Client, done chan<- struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+		close(done)
+	}()
+
+	for {
+		select {
+		case evt, ok := <-client.Send:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// hub closed the channel
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteJSON(evt); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
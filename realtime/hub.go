@@ -0,0 +1,286 @@
+package realtime
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sendBuffer is how many events may queue up for a single connection
+	// before it is considered too slow and dropped.
+	sendBuffer = 64
+	// backlogSize is how many recent events are kept per user so that
+	// reconnecting clients can resume where they left off.
+	backlogSize = 256
+	// backlogTTL is how long the backlog of a user with no connections is
+	// kept for them to resume.
+	backlogTTL = 10 * time.Minute
+	// forgetTTL is how long the hub remembers that it evicted a user's
+	// backlog, after which the user counts towards Hub.forgotten.
+	forgetTTL = 24 * time.Hour
+)
+
+// Event is a single notification pushed to connected clients. ID is assigned
+// by the hub as "<epoch>:<seq>": epoch identifies the process and seq
+// increases monotonically for its lifetime.
+type Event struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+
+	seq uint64
+}
+
+// Client is one live connection of a user (a browser tab, a phone, ...).
+// A user may have several clients at the same time.
+type Client struct {
+	UserID    string
+	SessionID string // login session the connection was authenticated with
+	Send      chan Event
+}
+
+// backlog holds the recent events of one user.
+type backlog struct {
+	events []Event
+	// lost is the seq of the newest event that was dropped (backlog
+	// overflow or eviction): resuming from before it can't be complete.
+	lost uint64
+	// idleSince is when the user's last connection went away; zero while
+	// they are connected.
+	idleSince time.Time
+}
+
+// Hub keeps track of every live connection, keyed by user ID, and fans
+// events out to them. It also remembers the last few events of every user
+// for clients that reconnect with a Last-Event-ID.
+type Hub struct {
+	mu       sync.RWMutex
+	epoch    string
+	seq      uint64
+	clients  map[string]map[*Client]struct{}
+	backlogs map[string]*backlog
+	// forgotten is the highest lost seq of the backlogs dropped after
+	// forgetTTL. It applies to every user, since the hub no longer knows
+	// whose they were.
+	forgotten uint64
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		epoch:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		clients:  make(map[string]map[*Client]struct{}),
+		backlogs: make(map[string]*backlog),
+	}
+}
+
+// Register adds a new connection for userID, authenticated with sessionID,
+// and returns its client handle.
+func (h *Hub) Register(userID, sessionID string) *Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.register(userID, sessionID)
+}
+
+// Resume registers a new connection like Register and also returns the
+// events the user missed after lastID. complete is false when some of those
+// events are no longer in the backlog, or lastID is from another process
+// (before a restart) or malformed, in which case the client should refetch
+// its state.
+func (h *Hub) Resume(userID, sessionID, lastID string) (c *Client, missed []Event, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c = h.register(userID, sessionID)
+	if lastID == "" {
+		return c, nil, true
+	}
+	epoch, seqStr, ok := strings.Cut(lastID, ":")
+	if !ok || epoch != h.epoch {
+		return c, nil, false
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil || seq > h.seq {
+		return c, nil, false
+	}
+
+	lost := h.forgotten
+	b := h.backlogs[userID]
+	if b == nil {
+		return c, nil, seq >= lost
+	}
+	if b.lost > lost {
+		lost = b.lost
+	}
+	for _, e := range b.events {
+		if e.seq > seq {
+			missed = append(missed, e)
+		}
+	}
+	return c, missed, seq >= lost
+}
+
+// Run evicts the backlogs of users who have had no connection for
+// backlogTTL, and forgets about them after forgetTTL, until ctx is
+// cancelled.
+func (h *Hub) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.evictIdle(now)
+		}
+	}
+}
+
+func (h *Hub) evictIdle(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for uid, b := range h.backlogs {
+		if len(h.clients[uid]) > 0 {
+			continue
+		}
+		switch idle := now.Sub(b.idleSince); {
+		case b.idleSince.IsZero():
+			// events for a user who never connected: start the clock
+			b.idleSince = now
+		case idle >= forgetTTL:
+			if b.lost > h.forgotten {
+				h.forgotten = b.lost
+			}
+			delete(h.backlogs, uid)
+		case idle >= backlogTTL && b.events != nil:
+			b.lost = h.seq
+			b.events = nil
+		}
+	}
+}
+
+func (h *Hub) register(userID, sessionID string) *Client {
+	if b := h.backlogs[userID]; b != nil {
+		b.idleSince = time.Time{}
+	}
+	c := &Client{UserID: userID, SessionID: sessionID, Send: make(chan Event, sendBuffer)}
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]struct{})
+	}
+	h.clients[userID][c] = struct{}{}
+	return c
+}
+
+// Unregister removes the client and closes its Send channel. It is safe to
+// call more than once.
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conns, ok := h.clients[c.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := conns[c]; !ok {
+		return
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.clients, c.UserID)
+		if b := h.backlogs[c.UserID]; b != nil {
+			b.idleSince = time.Now()
+		}
+	}
+	close(c.Send)
+}
+
+// CloseSessions drops every connection authenticated with one of
+// sessionIDs, e.g. once they are revoked: their tokens no longer work, so
+// they mustn't keep receiving events either.
+func (h *Hub) CloseSessions(sessionIDs []string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+	}
+
+	var closing []*Client
+	h.mu.RLock()
+	for _, conns := range h.clients {
+		for c := range conns {
+			if revoked[c.SessionID] {
+				closing = append(closing, c)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range closing {
+		h.Unregister(c)
+	}
+}
+
+// IsConnected reports whether the user has at least one live connection.
+func (h *Hub) IsConnected(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID]) > 0
+}
+
+// Publish assigns evt the next ID and delivers it to every connection of
+// every user in userIDs. Duplicate IDs are ignored. Connections whose buffer
+// is full are dropped so one stuck client cannot block everybody else.
+func (h *Hub) Publish(userIDs []string, evt Event) {
+	h.publish(userIDs, evt, true)
+}
+
+// PublishEphemeral is like Publish but the event is not kept in the resume
+// backlog, for signals that are meaningless after the fact (typing, ...).
+func (h *Hub) PublishEphemeral(userIDs []string, evt Event) {
+	h.publish(userIDs, evt, false)
+}
+
+func (h *Hub) publish(userIDs []string, evt Event, keep bool) {
+	var slow []*Client
+
+	h.mu.Lock()
+	h.seq++
+	evt.seq = h.seq
+	evt.ID = h.epoch + ":" + strconv.FormatUint(h.seq, 10)
+	seen := make(map[string]bool, len(userIDs))
+	for _, uid := range userIDs {
+		if seen[uid] {
+			continue
+		}
+		seen[uid] = true
+
+		if keep {
+			b := h.backlogs[uid]
+			if b == nil {
+				b = &backlog{}
+				h.backlogs[uid] = b
+			}
+			b.events = append(b.events, evt)
+			if n := len(b.events) - backlogSize; n > 0 {
+				b.lost = b.events[n-1].seq
+				b.events = b.events[n:]
+			}
+		}
+
+		for c := range h.clients[uid] {
+			select {
+			case c.Send <- evt:
+			default:
+				slow = append(slow, c)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range slow {
+		h.Unregister(c)
+	}
+}
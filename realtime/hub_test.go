@@ -0,0 +1,208 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+)
+
+// lastID publishes an event to userID and returns its ID.
+func lastID(h *Hub, userID string) string {
+	c := h.Register(userID, "")
+	defer h.Unregister(c)
+	h.Publish([]string{userID}, Event{Type: "test"})
+	return (<-c.Send).ID
+}
+
+// resume is Hub.Resume for a connection that closes right away.
+func resume(h *Hub, userID, lastID string) ([]Event, bool) {
+	c, missed, complete := h.Resume(userID, "", lastID)
+	h.Unregister(c)
+	return missed, complete
+}
+
+func TestResume(t *testing.T) {
+	t.Run("same epoch", func(t *testing.T) {
+		h := NewHub()
+		id := lastID(h, "alice")
+		h.Publish([]string{"alice", "bob"}, Event{Type: "a"})
+		h.Publish([]string{"alice"}, Event{Type: "b"})
+		h.PublishEphemeral([]string{"alice"}, Event{Type: "typing"})
+
+		missed, complete := resume(h, "alice", id)
+		if !complete {
+			t.Error("complete = false")
+		}
+		if len(missed) != 2 || missed[0].Type != "a" || missed[1].Type != "b" {
+			t.Errorf("missed = %+v, want a, b", missed)
+		}
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		h := NewHub()
+		id := lastID(h, "alice")
+		missed, complete := resume(h, "alice", id)
+		if !complete || len(missed) != 0 {
+			t.Errorf("missed = %+v, complete = %v", missed, complete)
+		}
+	})
+
+	t.Run("no last ID", func(t *testing.T) {
+		h := NewHub()
+		h.Publish([]string{"alice"}, Event{Type: "a"})
+		missed, complete := resume(h, "alice", "")
+		if !complete || len(missed) != 0 {
+			t.Errorf("missed = %+v, complete = %v", missed, complete)
+		}
+	})
+
+	t.Run("other epoch", func(t *testing.T) {
+		old := NewHub()
+		id := lastID(old, "alice")
+		time.Sleep(time.Millisecond) // epochs are derived from the clock
+
+		h := NewHub()
+		h.Publish([]string{"alice"}, Event{Type: "a"})
+		if missed, complete := resume(h, "alice", id); complete || len(missed) != 0 {
+			t.Errorf("missed = %+v, complete = %v", missed, complete)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		h := NewHub()
+		lastID(h, "alice")
+		for _, id := range []string{"42", h.epoch + ":x", h.epoch + ":99", "x:1"} {
+			if _, complete := resume(h, "alice", id); complete {
+				t.Errorf("%q: complete = true", id)
+			}
+		}
+	})
+
+	t.Run("overflowed backlog", func(t *testing.T) {
+		h := NewHub()
+		id := lastID(h, "alice")
+		for i := 0; i < backlogSize; i++ {
+			h.Publish([]string{"alice"}, Event{Type: "a"})
+		}
+		// lastID's event and nothing after it was dropped
+		missed, complete := resume(h, "alice", id)
+		if !complete || len(missed) != backlogSize {
+			t.Errorf("%d missed, complete = %v", len(missed), complete)
+		}
+
+		h.Publish([]string{"alice"}, Event{Type: "a"})
+		missed, complete = resume(h, "alice", id)
+		if complete {
+			t.Error("complete = true after the next event was dropped")
+		}
+		if len(missed) != backlogSize {
+			t.Errorf("%d missed, want the %d kept", len(missed), backlogSize)
+		}
+	})
+
+	t.Run("empty backlog", func(t *testing.T) {
+		h := NewHub()
+		id := lastID(h, "alice")
+		h.mu.Lock()
+		delete(h.backlogs, "alice") // a user whose events were never kept
+		h.mu.Unlock()
+
+		// events for others don't make alice's resume incomplete
+		for i := 0; i < 3; i++ {
+			h.Publish([]string{"bob"}, Event{Type: "a"})
+		}
+		if missed, complete := resume(h, "alice", id); !complete || len(missed) != 0 {
+			t.Errorf("missed = %+v, complete = %v", missed, complete)
+		}
+		if _, complete := resume(h, "carol", id); !complete {
+			t.Error("user without any events: complete = false")
+		}
+	})
+
+	t.Run("evicted backlog", func(t *testing.T) {
+		h := NewHub()
+		id := lastID(h, "alice")
+		h.Publish([]string{"alice"}, Event{Type: "a"})
+
+		now := time.Now()
+		h.evictIdle(now.Add(backlogTTL / 2))
+		if missed, complete := resume(h, "alice", id); !complete || len(missed) != 1 {
+			t.Fatalf("before the TTL: missed = %+v, complete = %v", missed, complete)
+		}
+		h.evictIdle(now.Add(backlogTTL + time.Minute))
+		if missed, complete := resume(h, "alice", id); complete || len(missed) != 0 {
+			t.Errorf("after the TTL: missed = %+v, complete = %v", missed, complete)
+		}
+	})
+}
+
+func TestEvictIdle(t *testing.T) {
+	h := NewHub()
+	id := lastID(h, "alice")
+	h.Publish([]string{"alice"}, Event{Type: "a"})
+	h.Publish([]string{"bob"}, Event{Type: "a"}) // never connected
+
+	start := time.Now()
+	h.evictIdle(start) // starts bob's clock
+	h.evictIdle(start.Add(backlogTTL + time.Minute))
+	h.mu.RLock()
+	if len(h.backlogs["alice"].events) != 0 || len(h.backlogs["bob"].events) != 0 {
+		t.Error("backlogs kept after backlogTTL")
+	}
+	h.mu.RUnlock()
+
+	h.evictIdle(start.Add(forgetTTL + time.Hour))
+	h.mu.RLock()
+	if len(h.backlogs) != 0 {
+		t.Errorf("%d backlogs kept after forgetTTL", len(h.backlogs))
+	}
+	h.mu.RUnlock()
+
+	// the hub no longer knows whose events were dropped, so nobody can
+	// resume from before them
+	if _, complete := resume(h, "alice", id); complete {
+		t.Error("alice: complete = true after her backlog was forgotten")
+	}
+	if _, complete := resume(h, "carol", id); complete {
+		t.Error("carol: complete = true from before the forgotten events")
+	}
+	if _, complete := resume(h, "carol", h.epoch+":3"); !complete {
+		t.Error("carol: complete = false from after the forgotten events")
+	}
+}
+
+func TestEvictIdleKeepsConnected(t *testing.T) {
+	h := NewHub()
+	c := h.Register("alice", "")
+	defer h.Unregister(c)
+	h.Publish([]string{"alice"}, Event{Type: "a"})
+
+	h.evictIdle(time.Now().Add(forgetTTL + time.Hour))
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if b := h.backlogs["alice"]; b == nil || len(b.events) != 1 {
+		t.Error("backlog of a connected user evicted")
+	}
+}
+
+func TestCloseSessions(t *testing.T) {
+	h := NewHub()
+	revoked := h.Register("alice", "s1")
+	kept := h.Register("alice", "s2")
+	other := h.Register("bob", "s3")
+	defer h.Unregister(kept)
+	defer h.Unregister(other)
+
+	h.CloseSessions([]string{"s1"})
+	if _, ok := <-revoked.Send; ok {
+		t.Error("client of the revoked session still open")
+	}
+	h.Publish([]string{"alice", "bob"}, Event{Type: "a"})
+	for _, c := range []*Client{kept, other} {
+		if e := <-c.Send; e.Type != "a" {
+			t.Errorf("%s: got %+v", c.SessionID, e)
+		}
+	}
+	if !h.IsConnected("alice") {
+		t.Error("alice disconnected along with one of her sessions")
+	}
+}
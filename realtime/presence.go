@@ -0,0 +1,178 @@
+package realtime
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"chat-app/models"
+)
+
+// Presence tracks who is online from client heartbeats. A user goes online
+// with their first heartbeat and is flipped back to offline once no
+// heartbeat arrived for Grace. Changes are written to users.is_online /
+// users.last_seen and broadcast to everyone sharing a group or DM with them.
+type Presence struct {
+	DB    *gorm.DB
+	Hub   *Hub
+	Grace time.Duration
+
+	mu       sync.Mutex
+	lastBeat map[string]time.Time
+	// userLocks serialize a user's state change and its DB write. Users
+	// share them by hash so the set stays fixed however many come and go.
+	userLocks [64]sync.Mutex
+}
+
+func NewPresence(db *gorm.DB, hub *Hub, grace time.Duration) *Presence {
+	return &Presence{DB: db, Hub: hub, Grace: grace, lastBeat: make(map[string]time.Time)}
+}
+
+// Heartbeat records activity for userID, marking them online if needed.
+func (p *Presence) Heartbeat(userID string) {
+	now := time.Now()
+	defer p.lockUser(userID)()
+
+	p.mu.Lock()
+	_, online := p.lastBeat[userID]
+	p.lastBeat[userID] = now
+	p.mu.Unlock()
+
+	if online {
+		return
+	}
+	p.DB.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"is_online": true, "last_seen": nil})
+	p.broadcast(userID, true, nil)
+}
+
+// SetOffline marks userID offline right away, e.g. on logout. Nothing
+// changes while the user still has a live connection (another device).
+func (p *Presence) SetOffline(userID string) error {
+	if p.Hub.IsConnected(userID) {
+		return nil
+	}
+	now := time.Now()
+	defer p.lockUser(userID)()
+
+	p.mu.Lock()
+	delete(p.lastBeat, userID)
+	p.mu.Unlock()
+
+	if err := p.markOffline(userID, now); err != nil {
+		return err
+	}
+	p.broadcast(userID, false, &now)
+	return nil
+}
+
+// Reset marks everyone still flagged online (from a previous run of the
+// server) as offline. Call it once at startup, before serving requests.
+func (p *Presence) Reset() error {
+	now := time.Now()
+	return p.DB.Model(&models.User{}).Where("is_online = ?", true).
+		Updates(map[string]interface{}{"is_online": false, "last_seen": &now}).Error
+}
+
+// Run expires stale users until ctx is cancelled.
+func (p *Presence) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Grace / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.sweep(now)
+		}
+	}
+}
+
+func (p *Presence) sweep(now time.Time) {
+	var expired []string
+	p.mu.Lock()
+	for uid, last := range p.lastBeat {
+		if now.Sub(last) > p.Grace {
+			expired = append(expired, uid)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, uid := range expired {
+		p.expire(uid, now)
+	}
+}
+
+// expire marks userID offline if they still haven't sent a heartbeat; one
+// may have come in since the sweep looked. A user with a live connection
+// who merely missed a heartbeat stays online, like in SetOffline.
+func (p *Presence) expire(userID string, now time.Time) {
+	defer p.lockUser(userID)()
+	connected := p.Hub.IsConnected(userID)
+
+	p.mu.Lock()
+	last, ok := p.lastBeat[userID]
+	if !ok || now.Sub(last) <= p.Grace {
+		p.mu.Unlock()
+		return
+	}
+	if connected {
+		// check again in another grace period
+		p.lastBeat[userID] = now
+		p.mu.Unlock()
+		return
+	}
+	delete(p.lastBeat, userID)
+	p.mu.Unlock()
+
+	if err := p.markOffline(userID, last); err != nil {
+		return
+	}
+	p.broadcast(userID, false, &last)
+}
+
+// lockUser locks userID's presence changes and returns the unlock func.
+func (p *Presence) lockUser(userID string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	mu := &p.userLocks[h.Sum32()%uint32(len(p.userLocks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (p *Presence) markOffline(userID string, lastSeen time.Time) error {
+	return p.DB.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"is_online": false, "last_seen": &lastSeen}).Error
+}
+
+func (p *Presence) broadcast(userID string, online bool, lastSeen *time.Time) {
+	ids, err := ContactIDs(p.DB, userID)
+	if err != nil {
+		return
+	}
+	p.Hub.Publish(append(ids, userID), Event{
+		Type: "presence.changed",
+		Data: map[string]interface{}{"user_id": userID, "is_online": online, "last_seen": lastSeen},
+	})
+}
+
+// ContactIDs returns everyone who shares a group or a 1‑on‑1 conversation
+// with userID.
+func ContactIDs(db *gorm.DB, userID string) ([]string, error) {
+	var ids []string
+	err := db.Raw(`
+		SELECT gm2.user_id FROM group_members gm1
+		JOIN group_members gm2 ON gm2.group_id = gm1.group_id AND gm2.deleted_at IS NULL
+		WHERE gm1.user_id = ? AND gm1.deleted_at IS NULL AND gm2.user_id <> ?
+		UNION
+		SELECT receiver_id FROM messages
+		WHERE sender_id = ? AND receiver_id IS NOT NULL AND deleted_at IS NULL
+		UNION
+		SELECT sender_id FROM messages
+		WHERE receiver_id = ? AND deleted_at IS NULL`,
+		userID, userID, userID, userID).Scan(&ids).Error
+	return ids, err
+}
@@ -0,0 +1,100 @@
+package realtime
+
+import (
+	"sync"
+	"time"
+)
+
+// Typing tracks ephemeral "user is typing" signals. Nothing is persisted: a
+// signal is pushed to the conversation's members and automatically stopped
+// after TTL unless the client refreshes it.
+type Typing struct {
+	Hub *Hub
+	TTL time.Duration
+
+	mu     sync.Mutex
+	active map[typingKey]*typingState
+}
+
+// typingKey identifies one user typing in one conversation. conversation is
+// "group:<id>" or "dm:<receiver id>".
+type typingKey struct {
+	userID       string
+	conversation string
+}
+
+type typingState struct {
+	timer      *time.Timer
+	recipients []string
+	payload    map[string]interface{}
+}
+
+func NewTyping(hub *Hub, ttl time.Duration) *Typing {
+	return &Typing{Hub: hub, TTL: ttl, active: make(map[typingKey]*typingState)}
+}
+
+// Start signals that userID is typing in a group (groupID set) or a 1‑on‑1
+// conversation (receiverID set). recipients must already be limited to the
+// users allowed to see that conversation. Calling Start again while the
+// signal is active only extends it.
+func (t *Typing) Start(userID string, groupID, receiverID *string, recipients []string) {
+	key := typingKey{userID: userID, conversation: conversationKey(groupID, receiverID)}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if st, ok := t.active[key]; ok {
+		if st.timer.Stop() {
+			st.timer.Reset(t.TTL)
+			return
+		}
+		// the timer already fired and its stop is waiting for t.mu: it
+		// will find a newer signal in place and leave it alone
+	}
+
+	st := &typingState{
+		recipients: recipients,
+		payload:    map[string]interface{}{"user_id": userID, "group_id": groupID, "receiver_id": receiverID},
+	}
+	st.timer = time.AfterFunc(t.TTL, func() { t.stop(key, st) })
+	t.active[key] = st
+
+	t.Hub.PublishEphemeral(recipients, Event{Type: "typing.started", Data: st.payload})
+}
+
+// Stop ends the signal early, e.g. when the user sent the message or
+// cleared the input.
+func (t *Typing) Stop(userID string, groupID, receiverID *string) {
+	key := typingKey{userID: userID, conversation: conversationKey(groupID, receiverID)}
+
+	t.mu.Lock()
+	st, ok := t.active[key]
+	t.mu.Unlock()
+	if ok {
+		st.timer.Stop()
+		t.stop(key, st)
+	}
+}
+
+func (t *Typing) stop(key typingKey, st *typingState) {
+	t.mu.Lock()
+	if t.active[key] != st {
+		// already stopped (or replaced by a newer signal)
+		t.mu.Unlock()
+		return
+	}
+	delete(t.active, key)
+	t.mu.Unlock()
+
+	t.Hub.PublishEphemeral(st.recipients, Event{Type: "typing.stopped", Data: st.payload})
+}
+
+func conversationKey(groupID, receiverID *string) string {
+	if groupID != nil {
+		return "group:" + *groupID
+	}
+	if receiverID != nil {
+		return "dm:" + *receiverID
+	}
+	return ""
+}
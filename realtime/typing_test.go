@@ -0,0 +1,63 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+)
+
+// typingEvents collects the typing events delivered to client c until the
+// channel has been quiet for wait.
+func typingEvents(c *Client, wait time.Duration) []string {
+	var types []string
+	for {
+		select {
+		case e := <-c.Send:
+			types = append(types, e.Type)
+		case <-time.After(wait):
+			return types
+		}
+	}
+}
+
+func TestTypingExtendAfterExpiry(t *testing.T) {
+	hub := NewHub()
+	bob := hub.Register("bob", "")
+	typing := NewTyping(hub, 20*time.Millisecond)
+	group := "g1"
+
+	typing.Start("alice", &group, nil, []string{"bob"})
+	// hold the lock across the expiry so the timer's stop has to wait, as
+	// when Start races with it
+	typing.mu.Lock()
+	time.Sleep(40 * time.Millisecond)
+	typing.mu.Unlock()
+	typing.Start("alice", &group, nil, []string{"bob"})
+
+	got := typingEvents(bob, 100*time.Millisecond)
+	want := []string{"typing.started", "typing.started", "typing.stopped"}
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTypingExtend(t *testing.T) {
+	hub := NewHub()
+	bob := hub.Register("bob", "")
+	typing := NewTyping(hub, 200*time.Millisecond)
+	group := "g1"
+
+	typing.Start("alice", &group, nil, []string{"bob"})
+	time.Sleep(120 * time.Millisecond)
+	typing.Start("alice", &group, nil, []string{"bob"})
+	if got := typingEvents(bob, 120*time.Millisecond); len(got) != 1 || got[0] != "typing.started" {
+		t.Fatalf("events before the extended TTL = %v", got)
+	}
+	if got := typingEvents(bob, 300*time.Millisecond); len(got) != 1 || got[0] != "typing.stopped" {
+		t.Fatalf("events after the extended TTL = %v", got)
+	}
+}
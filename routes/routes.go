@@ -1,20 +1,41 @@
 package routes
 
 import (
+    "time"
+
     "github.com/gin-gonic/gin"
     "gorm.io/gorm"
 
     "chat-app/controllers"
+    "chat-app/mailer"
+    "chat-app/media"
+    "chat-app/realtime"
+    "chat-app/search"
+    "chat-app/storage"
 )
 
-func RegisterRoutes(r *gin.Engine, db *gorm.DB) {
-    uc := controllers.NewUserController(db)
-	gc := controllers.NewGroupController(db)
-	mc := controllers.NewMessageController(db)
+func RegisterRoutes(r *gin.Engine, db *gorm.DB, hub *realtime.Hub, presence *realtime.Presence, store storage.Storage, thumbs *media.Thumbnailer, idx search.Index, mail mailer.Mailer) {
+    typing := realtime.NewTyping(hub, 5*time.Second)
+
+    uc := controllers.NewUserController(db, presence, mail)
+	gc := controllers.NewGroupController(db, hub)
+	mc := controllers.NewMessageController(db, hub, idx)
+	rc := controllers.NewRealtimeController(db, hub, presence, typing)
+	ic := controllers.NewInviteController(db, hub)
+	xc := controllers.NewReactionController(db, hub)
+	mnc := controllers.NewMentionController(db)
+	ac := controllers.NewAttachmentController(db, store, thumbs)
+	sc := controllers.NewSearchController(db, idx)
+	cc := controllers.NewConversationController(db, hub)
 
     // public endpoints
     r.POST("/api/register", uc.Register)
     r.POST("/api/login", uc.Login)
+    r.POST("/api/login/2fa", uc.LoginTwoFactor)
+    r.POST("/api/token/refresh", uc.RefreshToken)
+    r.POST("/api/password/forgot", uc.ForgotPassword)
+    r.POST("/api/password/reset", uc.ResetPassword)
+    r.POST("/api/email/verify", uc.VerifyEmail)
 
     // protected endpoints
     api := r.Group("/api").Use(uc.JWTAuthMiddleware())
@@ -22,18 +43,63 @@ func RegisterRoutes(r *gin.Engine, db *gorm.DB) {
         api.GET("/users", uc.GetUsers)
         api.GET("/users/:id", uc.GetUser)
         api.PUT("/users/:id", uc.UpdateUser)
+        api.POST("/users/:id/unlock", uc.UnlockUser)
         // api.DELETE("/users/:id", uc.DeleteUser)
         api.POST("/logout", uc.Logout)
+        api.GET("/sessions", uc.GetSessions)
+        api.DELETE("/sessions/others", uc.RevokeOtherSessions)
+        api.DELETE("/sessions/:id", uc.RevokeSession)
+        api.POST("/2fa/setup", uc.SetupTwoFactor)
+        api.POST("/2fa/enable", uc.EnableTwoFactor)
+        api.POST("/2fa/disable", uc.DisableTwoFactor)
+        api.POST("/email/verification", uc.SendVerification)
 
 		api.POST("/groups", gc.CreateGroup)
         api.GET("/groups", gc.GetGroups)
         api.POST("/groups/:id/join", gc.JoinGroup)
         api.POST("/groups/:id/leave", gc.LeaveGroup)
         api.DELETE("/groups/:id", gc.DeleteGroup)
+		api.PUT("/groups/:id", gc.UpdateGroup)
+		api.POST("/groups/:id/transfer", gc.TransferOwnership)
+		api.POST("/groups/:id/members/:userId/promote", gc.PromoteMember)
+		api.POST("/groups/:id/members/:userId/demote", gc.DemoteMember)
+		api.DELETE("/groups/:id/members/:userId", gc.KickMember)
+
+		api.POST("/groups/:id/invites", ic.CreateInvite)
+		api.GET("/groups/:id/invites", ic.GetInvites)
+		api.DELETE("/groups/:id/invites/:inviteId", ic.RevokeInvite)
+		api.POST("/invites/:token/join", ic.AcceptInvite)
+		api.GET("/groups/:id/requests", ic.GetJoinRequests)
+		api.POST("/groups/:id/requests/:requestId/approve", ic.ApproveJoinRequest)
+		api.POST("/groups/:id/requests/:requestId/reject", ic.RejectJoinRequest)
 
 		api.POST("/messages", mc.SendMessage)
 		api.GET("/messages", mc.GetMessages)
+		api.GET("/conversations", cc.GetConversations)
+		api.POST("/conversations/read", cc.MarkConversationRead)
 		api.POST("/messages/:id/read", mc.MarkRead)
+		api.POST("/messages/:id/delivered", mc.MarkDelivered)
+		api.GET("/messages/:id/receipts", mc.GetReceipts)
+		api.PATCH("/messages/:id", mc.EditMessage)
 		api.DELETE("/messages/:id", mc.DeleteMessage)
+		api.GET("/messages/:id/revisions", mc.GetRevisions)
+		api.GET("/messages/:id/replies", mc.GetReplies)
+		api.POST("/messages/:id/thread/read", mc.MarkThreadRead)
+		api.POST("/messages/:id/reactions", xc.AddReaction)
+		api.DELETE("/messages/:id/reactions/:emoji", xc.RemoveReaction)
+
+		api.GET("/mentions", mnc.GetMentions)
+		api.POST("/mentions/:id/read", mnc.MarkMentionRead)
+
+		api.POST("/attachments", ac.Upload)
+		api.GET("/attachments/:id/download", ac.Download)
+		api.GET("/attachments/:id/thumbnails/:size", ac.Thumbnail)
+
+		api.GET("/search/messages", sc.SearchMessages)
+
+		api.GET("/ws", rc.ServeWS)
+		api.GET("/events", rc.StreamEvents)
+		api.POST("/presence/heartbeat", rc.Heartbeat)
+		api.POST("/typing", rc.SetTyping)
     }
 }
@@ -0,0 +1,249 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"chat-app/models"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Memory is an inverted index kept in process memory. It is meant for a
+// single instance (development, SQLite-backed tests); every instance holds
+// its own copy, filled by Rebuild at startup.
+type Memory struct {
+	mu       sync.RWMutex
+	docs     map[string]*memDoc
+	postings map[string]map[string]int // term -> doc ID -> term frequency
+	totalLen int
+}
+
+type memDoc struct {
+	Document
+	length int      // number of tokens
+	terms  []string // distinct terms, to clean up postings on removal
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		docs:     make(map[string]*memDoc),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+// Rebuild replaces the index content with every non-deleted message in db.
+func (m *Memory) Rebuild(ctx context.Context, db *gorm.DB) error {
+	var withFiles []string
+	if err := db.WithContext(ctx).Model(&models.Attachment{}).
+		Where("message_id IS NOT NULL").Distinct().Pluck("message_id", &withFiles).Error; err != nil {
+		return err
+	}
+	hasFile := make(map[string]bool, len(withFiles))
+	for _, id := range withFiles {
+		hasFile[id] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs = make(map[string]*memDoc)
+	m.postings = make(map[string]map[string]int)
+	m.totalLen = 0
+
+	var batch []models.Message
+	return db.WithContext(ctx).
+		Select("id, content, sender_id, group_id, receiver_id, sent_at").
+		FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+			for i := range batch {
+				doc := DocumentFromMessage(&batch[i])
+				doc.HasAttachment = hasFile[doc.ID]
+				m.add(doc)
+			}
+			return nil
+		}).Error
+}
+
+func (m *Memory) Index(ctx context.Context, doc Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(doc.ID)
+	m.add(doc)
+	return nil
+}
+
+func (m *Memory) Remove(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+	return nil
+}
+
+func (m *Memory) add(doc Document) {
+	toks := tokenize(doc.Content)
+	d := &memDoc{Document: doc, length: len(toks)}
+	for _, t := range toks {
+		p := m.postings[t.text]
+		if p == nil {
+			p = make(map[string]int)
+			m.postings[t.text] = p
+		}
+		if p[doc.ID] == 0 {
+			d.terms = append(d.terms, t.text)
+		}
+		p[doc.ID]++
+	}
+	m.docs[doc.ID] = d
+	m.totalLen += d.length
+}
+
+func (m *Memory) remove(id string) {
+	d, ok := m.docs[id]
+	if !ok {
+		return
+	}
+	for _, t := range d.terms {
+		delete(m.postings[t], id)
+		if len(m.postings[t]) == 0 {
+			delete(m.postings, t)
+		}
+	}
+	delete(m.docs, id)
+	m.totalLen -= d.length
+}
+
+func (m *Memory) Search(ctx context.Context, q Query) (*Result, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := &Result{Hits: []Hit{}}
+	if len(q.Terms) == 0 || len(m.docs) == 0 {
+		return res, nil
+	}
+
+	postings := make([]map[string]int, len(q.Terms))
+	for i, t := range q.Terms {
+		postings[i] = m.termPostings(t)
+	}
+
+	// walk the rarest term's postings; every other term must match too
+	rarest := postings[0]
+	for _, p := range postings {
+		if len(p) < len(rarest) {
+			rarest = p
+		}
+	}
+
+	groups := make(map[string]bool, len(q.GroupIDs))
+	for _, id := range q.GroupIDs {
+		groups[id] = true
+	}
+
+	n := float64(len(m.docs))
+	avgLen := float64(m.totalLen) / n
+
+	type scored struct {
+		doc   *memDoc
+		score float64
+	}
+	var matches []scored
+	for id := range rarest {
+		d := m.docs[id]
+		if !m.visible(d, q, groups) {
+			continue
+		}
+		score, ok := 0.0, true
+		for _, p := range postings {
+			tf := float64(p[id])
+			if tf == 0 {
+				ok = false
+				break
+			}
+			df := float64(len(p))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(d.length)/avgLen))
+		}
+		if ok {
+			matches = append(matches, scored{d, score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if !a.doc.SentAt.Equal(b.doc.SentAt) {
+			return a.doc.SentAt.After(b.doc.SentAt)
+		}
+		return a.doc.ID > b.doc.ID
+	})
+
+	res.Total = int64(len(matches))
+	if q.Offset >= len(matches) {
+		return res, nil
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	for _, s := range matches {
+		res.Hits = append(res.Hits, Hit{
+			MessageID: s.doc.ID,
+			Score:     s.score,
+			Snippet:   Highlight(s.doc.Content, q.Terms, snippetWidth),
+		})
+	}
+	return res, nil
+}
+
+// termPostings returns doc ID -> term frequency for a query term. A prefix
+// term counts every indexed word it matches.
+func (m *Memory) termPostings(term string) map[string]int {
+	prefix, ok := strings.CutSuffix(term, "*")
+	if !ok {
+		return m.postings[term]
+	}
+	merged := make(map[string]int)
+	for word, p := range m.postings {
+		if !strings.HasPrefix(word, prefix) {
+			continue
+		}
+		for id, tf := range p {
+			merged[id] += tf
+		}
+	}
+	return merged
+}
+
+// visible applies the access rules and filters of q to d.
+func (m *Memory) visible(d *memDoc, q Query, groups map[string]bool) bool {
+	if d.GroupID != nil {
+		if !groups[*d.GroupID] {
+			return false
+		}
+	} else if d.SenderID != q.UserID && (d.ReceiverID == nil || *d.ReceiverID != q.UserID) {
+		return false
+	}
+	switch {
+	case q.SenderID != "" && d.SenderID != q.SenderID:
+		return false
+	case q.GroupID != "" && (d.GroupID == nil || *d.GroupID != q.GroupID):
+		return false
+	case q.From != nil && d.SentAt.Before(*q.From):
+		return false
+	case q.To != nil && !d.SentAt.Before(*q.To):
+		return false
+	case q.HasAttachment != nil && d.HasAttachment != *q.HasAttachment:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func strPtr(s string) *string { return &s }
+
+// newTestIndex indexes a few messages from "alice" to "bob" and in group
+// "g1". Each is sent a minute after the previous one.
+func newTestIndex(t *testing.T, contents ...string) *Memory {
+	t.Helper()
+	idx := NewMemory()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, content := range contents {
+		doc := Document{
+			ID:         string(rune('a' + i)),
+			Content:    content,
+			SenderID:   "alice",
+			ReceiverID: strPtr("bob"),
+			SentAt:     base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := idx.Index(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return idx
+}
+
+func search(t *testing.T, idx *Memory, query string) []string {
+	t.Helper()
+	res, err := idx.Search(context.Background(), Query{Terms: Terms(query), UserID: "bob", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(res.Hits))
+	for i, h := range res.Hits {
+		ids[i] = h.MessageID
+	}
+	if int(res.Total) != len(ids) {
+		t.Errorf("Total = %d, want %d", res.Total, len(ids))
+	}
+	return ids
+}
+
+func TestMemoryRanking(t *testing.T) {
+	idx := newTestIndex(t,
+		"lunch is at noon",                         // a
+		"deploy deploy deploy tonight",             // b
+		"the deploy went fine, lunch after deploy", // c
+		"nothing relevant here",                    // d
+	)
+
+	if got := strings.Join(search(t, idx, "deploy"), ","); got != "b,c" {
+		t.Errorf("deploy: got %s, want b,c (more occurrences first)", got)
+	}
+	// every term must match
+	if got := strings.Join(search(t, idx, "deploy lunch"), ","); got != "c" {
+		t.Errorf("deploy lunch: got %s, want c", got)
+	}
+	if got := search(t, idx, "missing"); len(got) != 0 {
+		t.Errorf("missing: got %v, want nothing", got)
+	}
+	// equal scores: newest first
+	idx = newTestIndex(t, "hello there", "hello there")
+	if got := strings.Join(search(t, idx, "hello"), ","); got != "b,a" {
+		t.Errorf("ties: got %s, want b,a", got)
+	}
+}
+
+func TestMemoryPrefix(t *testing.T) {
+	idx := newTestIndex(t, "hello world", "help wanted", "shell script")
+
+	if got := strings.Join(search(t, idx, "hel*"), ","); got != "a,b" && got != "b,a" {
+		t.Errorf("hel*: got %s, want a and b", got)
+	}
+	// without the star the word must match exactly
+	if got := search(t, idx, "hel"); len(got) != 0 {
+		t.Errorf("hel: got %v, want nothing", got)
+	}
+	if got := strings.Join(search(t, idx, "hel* wor*"), ","); got != "a" {
+		t.Errorf("hel* wor*: got %s, want a", got)
+	}
+}
+
+func TestMemoryRemoveAndReindex(t *testing.T) {
+	idx := newTestIndex(t, "first draft", "second draft")
+	ctx := context.Background()
+
+	if err := idx.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(search(t, idx, "draft"), ","); got != "b" {
+		t.Errorf("after remove: got %s, want b", got)
+	}
+	if got := search(t, idx, "first"); len(got) != 0 {
+		t.Errorf("removed message still found: %v", got)
+	}
+	if _, ok := idx.postings["first"]; ok {
+		t.Error("postings of a removed message were kept")
+	}
+
+	// an edit replaces the old content
+	if err := idx.Index(ctx, Document{ID: "b", Content: "final version", SenderID: "alice", ReceiverID: strPtr("bob")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(t, idx, "draft"); len(got) != 0 {
+		t.Errorf("old content still found: %v", got)
+	}
+	if got := strings.Join(search(t, idx, "final"), ","); got != "b" {
+		t.Errorf("new content: got %s, want b", got)
+	}
+}
+
+func TestMemoryVisibilityAndFilters(t *testing.T) {
+	idx := NewMemory()
+	ctx := context.Background()
+	now := time.Now()
+	docs := []Document{
+		{ID: "dm", Content: "report", SenderID: "alice", ReceiverID: strPtr("bob"), SentAt: now.Add(-time.Hour)},
+		{ID: "other-dm", Content: "report", SenderID: "carol", ReceiverID: strPtr("dave"), SentAt: now},
+		{ID: "group", Content: "report", SenderID: "carol", GroupID: strPtr("g1"), HasAttachment: true, SentAt: now},
+		{ID: "foreign-group", Content: "report", SenderID: "carol", GroupID: strPtr("g2"), SentAt: now},
+	}
+	for _, d := range docs {
+		if err := idx.Index(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(q Query) string {
+		q.Terms, q.UserID, q.GroupIDs = Terms("report"), "bob", []string{"g1"}
+		res, err := idx.Search(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, h := range res.Hits {
+			ids = append(ids, h.MessageID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	if got := run(Query{}); got != "group,dm" {
+		t.Errorf("visible: got %s, want group,dm", got)
+	}
+	if got := run(Query{SenderID: "alice"}); got != "dm" {
+		t.Errorf("sender filter: got %s", got)
+	}
+	if got := run(Query{GroupID: "g1"}); got != "group" {
+		t.Errorf("group filter: got %s", got)
+	}
+	yes := true
+	if got := run(Query{HasAttachment: &yes}); got != "group" {
+		t.Errorf("has_attachment filter: got %s", got)
+	}
+	from := now.Add(-time.Minute)
+	if got := run(Query{From: &from}); got != "group" {
+		t.Errorf("from filter: got %s", got)
+	}
+	if got := run(Query{Offset: 1, Limit: 1}); got != "dm" {
+		t.Errorf("pagination: got %s", got)
+	}
+}
+
+func TestTerms(t *testing.T) {
+	got := strings.Join(Terms("Hello, hello WORLD! fo* bar-baz"), " ")
+	if want := "hello world fo* bar baz"; got != want {
+		t.Errorf("Terms = %q, want %q", got, want)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		content string
+		terms   []string
+		want    string
+	}{
+		{"Deploy is done", []string{"deploy"}, "<mark>Deploy</mark> is done"},
+		{"<b>deploy</b> & more", []string{"deploy"}, "&lt;b&gt;<mark>deploy</mark>&lt;/b&gt; &amp; more"},
+		{"helping hands help", []string{"help*"}, "<mark>helping</mark> hands <mark>help</mark>"},
+		{"no match here", []string{"deploy"}, "no match here"},
+	}
+	for _, tt := range tests {
+		if got := Highlight(tt.content, tt.terms, 160); got != tt.want {
+			t.Errorf("Highlight(%q, %v) = %q, want %q", tt.content, tt.terms, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightExcerpt(t *testing.T) {
+	content := strings.Repeat("filler ", 50) + "needle" + strings.Repeat(" filler", 50)
+	got := Highlight(content, []string{"needle"}, 60)
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("excerpt not marked as cut: %q", got)
+	}
+	if !strings.Contains(got, "<mark>needle</mark>") {
+		t.Errorf("match missing from excerpt: %q", got)
+	}
+	if n := len(strings.NewReplacer("…", "", "<mark>", "", "</mark>", "").Replace(got)); n > 60 {
+		t.Errorf("excerpt is %d bytes, want at most 60", n)
+	}
+}
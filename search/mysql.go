@@ -0,0 +1,111 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"chat-app/models"
+)
+
+// snippetWidth is the approximate length of a highlighted excerpt.
+const snippetWidth = 160
+
+// fulltextIndex is the FULLTEXT index on messages.content.
+const fulltextIndex = "idx_messages_content"
+
+// MySQL searches the FULLTEXT index on messages.content. The index is
+// maintained by the database, so Index and Remove do nothing.
+type MySQL struct {
+	DB *gorm.DB
+}
+
+// NewMySQL creates the FULLTEXT index if it doesn't exist yet. It is not
+// declared on models.Message because other databases (SQLite in tests)
+// don't support it.
+func NewMySQL(db *gorm.DB) (*MySQL, error) {
+	if name := db.Dialector.Name(); name != "mysql" {
+		return nil, errors.New("mysql search needs a MySQL database, not " + name)
+	}
+	if !db.Migrator().HasIndex(&models.Message{}, fulltextIndex) {
+		if err := db.Exec("CREATE FULLTEXT INDEX " + fulltextIndex + " ON messages (content)").Error; err != nil {
+			return nil, err
+		}
+	}
+	return &MySQL{DB: db}, nil
+}
+
+func (m *MySQL) Index(ctx context.Context, doc Document) error { return nil }
+
+func (m *MySQL) Remove(ctx context.Context, id string) error { return nil }
+
+func (m *MySQL) Search(ctx context.Context, q Query) (*Result, error) {
+	// boolean mode so every term is required ("+word", "+prefix*"); tokens
+	// only contain letters and digits, so they can't smuggle in operators
+	words := make([]string, len(q.Terms))
+	for i, t := range q.Terms {
+		words[i] = "+" + t
+	}
+	against := strings.Join(words, " ")
+
+	base := func() *gorm.DB {
+		tx := m.DB.WithContext(ctx).Model(&models.Message{}).
+			Where("MATCH(messages.content) AGAINST (? IN BOOLEAN MODE)", against).
+			Where("messages.group_id IN ? OR (messages.group_id IS NULL AND (messages.sender_id = ? OR messages.receiver_id = ?))",
+				nonEmpty(q.GroupIDs), q.UserID, q.UserID)
+		if q.SenderID != "" {
+			tx = tx.Where("messages.sender_id = ?", q.SenderID)
+		}
+		if q.GroupID != "" {
+			tx = tx.Where("messages.group_id = ?", q.GroupID)
+		}
+		if q.From != nil {
+			tx = tx.Where("messages.sent_at >= ?", *q.From)
+		}
+		if q.To != nil {
+			tx = tx.Where("messages.sent_at < ?", *q.To)
+		}
+		if q.HasAttachment != nil {
+			exists := "EXISTS (SELECT 1 FROM attachments WHERE attachments.message_id = messages.id)"
+			if !*q.HasAttachment {
+				exists = "NOT " + exists
+			}
+			tx = tx.Where(exists)
+		}
+		return tx
+	}
+
+	var total int64
+	if err := base().Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ID      string
+		Content string
+		Score   float64
+	}
+	if err := base().
+		Select("messages.id, messages.content, MATCH(messages.content) AGAINST (? IN BOOLEAN MODE) AS score", against).
+		Order("score DESC, messages.sent_at DESC, messages.id DESC").
+		Offset(q.Offset).Limit(q.Limit).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	res := &Result{Total: total, Hits: make([]Hit, len(rows))}
+	for i, r := range rows {
+		res.Hits[i] = Hit{MessageID: r.ID, Score: r.Score, Snippet: Highlight(r.Content, q.Terms, snippetWidth)}
+	}
+	return res, nil
+}
+
+// nonEmpty keeps "IN ?" valid SQL for users without any group.
+func nonEmpty(ids []string) []string {
+	if len(ids) == 0 {
+		return []string{""}
+	}
+	return ids
+}
@@ -0,0 +1,234 @@
+// Package search finds messages by their content. The Index interface hides
+// the engine: MySQL's FULLTEXT index in production, or an in-process
+// inverted index when the database can't do full-text search itself.
+package search
+
+import (
+	"context"
+	"errors"
+	"html"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+
+	"chat-app/models"
+)
+
+// maxTerms caps how many words of a query are used.
+const maxTerms = 10
+
+// Document is the searchable part of a message.
+type Document struct {
+	ID            string
+	Content       string
+	SenderID      string
+	GroupID       *string
+	ReceiverID    *string
+	HasAttachment bool
+	SentAt        time.Time
+}
+
+func DocumentFromMessage(msg *models.Message) Document {
+	return Document{
+		ID:            msg.ID,
+		Content:       msg.Content,
+		SenderID:      msg.SenderID,
+		GroupID:       msg.GroupID,
+		ReceiverID:    msg.ReceiverID,
+		HasAttachment: len(msg.Attachments) > 0,
+		SentAt:        msg.SentAt,
+	}
+}
+
+// Query is a search on behalf of UserID. Only messages the user can see are
+// matched: messages in GroupIDs (the groups they are a member of) and 1‑on‑1
+// messages they sent or received. The remaining fields are optional filters.
+type Query struct {
+	Terms    []string // from Terms(); every one must match
+	UserID   string
+	GroupIDs []string
+
+	SenderID      string
+	GroupID       string
+	From, To      *time.Time
+	HasAttachment *bool
+
+	Limit, Offset int
+}
+
+type Hit struct {
+	MessageID string
+	Score     float64
+	Snippet   string // HTML-escaped, matches wrapped in <mark>
+}
+
+type Result struct {
+	Hits  []Hit
+	Total int64
+}
+
+type Index interface {
+	// Index adds or replaces a message.
+	Index(ctx context.Context, doc Document) error
+	// Remove drops a message. Removing an unknown ID is not an error.
+	Remove(ctx context.Context, id string) error
+	// Search returns matching messages, best match first.
+	Search(ctx context.Context, q Query) (*Result, error)
+}
+
+// FromEnv builds the index configured by SEARCH_DRIVER:
+//   - "mysql" (default): MATCH ... AGAINST on the messages table
+//   - "memory": an in-process index, filled from the database at startup
+func FromEnv(db *gorm.DB) (Index, error) {
+	switch os.Getenv("SEARCH_DRIVER") {
+	case "", "mysql":
+		return NewMySQL(db)
+	case "memory":
+		idx := NewMemory()
+		if err := idx.Rebuild(context.Background(), db); err != nil {
+			return nil, err
+		}
+		return idx, nil
+	default:
+		return nil, errors.New("unknown SEARCH_DRIVER " + os.Getenv("SEARCH_DRIVER"))
+	}
+}
+
+// Terms splits a user query into lower-cased, de-duplicated words. A word
+// directly followed by "*" is kept as a prefix term: "hel*" matches "hello".
+func Terms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, t := range tokenize(query) {
+		term := t.text
+		if strings.HasPrefix(query[t.end:], "*") {
+			term += "*"
+		}
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+		if len(terms) == maxTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// matchTerm reports whether the (lower-cased) word matches term from Terms.
+func matchTerm(term, word string) bool {
+	if prefix, ok := strings.CutSuffix(term, "*"); ok {
+		return strings.HasPrefix(word, prefix)
+	}
+	return term == word
+}
+
+// matchAny reports whether word matches one of terms.
+func matchAny(terms []string, word string) bool {
+	for _, t := range terms {
+		if matchTerm(t, word) {
+			return true
+		}
+	}
+	return false
+}
+
+type token struct {
+	text       string // lower-cased
+	start, end int    // byte offsets in the original string
+}
+
+// tokenize splits s into words: runs of letters and digits.
+func tokenize(s string) []token {
+	var toks []token
+	start := -1
+	for i, r := range s {
+		word := unicode.IsLetter(r) || unicode.IsNumber(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			toks = append(toks, token{strings.ToLower(s[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, token{strings.ToLower(s[start:]), start, len(s)})
+	}
+	return toks
+}
+
+// Highlight returns an excerpt of content of about width bytes around the
+// first matching term, HTML-escaped, with every match wrapped in <mark>.
+func Highlight(content string, terms []string, width int) string {
+	toks := tokenize(content)
+
+	first := -1
+	for i, t := range toks {
+		if matchAny(terms, t.text) {
+			first = i
+			break
+		}
+	}
+
+	// start a few words before the first match so it has some context;
+	// short messages are shown whole
+	start := 0
+	if first > 0 && len(content) > width {
+		j := first
+		for j > 0 && toks[first].start-toks[j-1].start < width/3 {
+			j--
+		}
+		if j > 0 {
+			start = toks[j].start
+		}
+	}
+	end := len(content)
+	if end-start > width {
+		end = start
+		for _, t := range toks {
+			if t.start < start {
+				continue
+			}
+			if t.end-start > width {
+				break
+			}
+			end = t.end
+		}
+		if end == start { // one very long word
+			end = start + width
+			for end > start && !utf8RuneStart(content[end]) {
+				end--
+			}
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, t := range toks {
+		if t.start < start || t.end > end {
+			continue
+		}
+		if !matchAny(terms, t.text) {
+			continue
+		}
+		b.WriteString(html.EscapeString(content[pos:t.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(content[t.start:t.end]))
+		b.WriteString("</mark>")
+		pos = t.end
+	}
+	b.WriteString(html.EscapeString(content[pos:end]))
+	if end < len(content) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func utf8RuneStart(b byte) bool { return b&0xC0 != 0x80 }
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalPutOpenDelete(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const key = "attachments/ab/abcdef.txt"
+	body := "hello storage"
+	if err := store.Put(ctx, key, strings.NewReader(body), int64(len(body)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rc, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Errorf("Open returned %q, want %q", got, body)
+	}
+
+	if u, err := store.SignedURL(ctx, key, time.Minute, "text/plain", "attachment"); err != nil || u != "" {
+		t.Errorf("SignedURL = %q, %v; want empty", u, err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+}
+
+func TestLocalPutOverwrites(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{"first", "second"} {
+		if err := store.Put(ctx, "k", strings.NewReader(body), int64(len(body)), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(root, "k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "second" {
+		t.Errorf("file holds %q, want %q", got, "second")
+	}
+
+	// no temp files left behind
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("root has %d entries, want 1", len(entries))
+	}
+}
+
+func TestLocalRejectsEscapingKeys(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "secret"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "/", "../secret", "a/../../secret", "..", "a/.."} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if rc, err := store.Open(ctx, key); err == nil {
+			rc.Close()
+			t.Errorf("Open(%q) succeeded", key)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(parent, "secret")); err != nil {
+		t.Errorf("file outside root was touched: %v", err)
+	}
+}
+
+func TestLocalKeepsAbsoluteKeysInsideRoot(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := store.path("/etc/passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "etc", "passwd"); p != want {
+		t.Errorf("path = %q, want %q", p, want)
+	}
+}
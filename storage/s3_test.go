@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestS3 connects to the MinIO / S3 server in S3_TEST_ENDPOINT, e.g.
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	S3_TEST_ENDPOINT=localhost:9000 go test ./storage
+//
+// S3_TEST_ACCESS_KEY / S3_TEST_SECRET_KEY default to MinIO's minioadmin.
+// The test is skipped without S3_TEST_ENDPOINT.
+func newTestS3(t *testing.T) *S3 {
+	t.Helper()
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT not set")
+	}
+	access, secret := os.Getenv("S3_TEST_ACCESS_KEY"), os.Getenv("S3_TEST_SECRET_KEY")
+	if access == "" {
+		access, secret = "minioadmin", "minioadmin"
+	}
+	bucket := os.Getenv("S3_TEST_BUCKET")
+	if bucket == "" {
+		bucket = "chat-app-test"
+	}
+	store, err := NewS3(S3Config{
+		Endpoint:  endpoint,
+		AccessKey: access,
+		SecretKey: secret,
+		Bucket:    bucket,
+		UseSSL:    os.Getenv("S3_TEST_USE_SSL") == "true",
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	return store
+}
+
+func TestS3PutOpenDelete(t *testing.T) {
+	store := newTestS3(t)
+	ctx := context.Background()
+	key := "test/" + uuid.NewString() + ".html"
+	body := "<script>alert(1)</script>"
+
+	if err := store.Put(ctx, key, strings.NewReader(body), int64(len(body)), "text/html"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	t.Cleanup(func() { store.Delete(context.Background(), key) })
+
+	rc, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Errorf("Open returned %q, want %q", got, body)
+	}
+
+	u, err := store.SignedURL(ctx, key, time.Minute, "text/html", `attachment; filename="x.html"`)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("GET signed URL: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET signed URL: status %d", resp.StatusCode)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename="x.html"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Package storage stores uploaded files (attachments, thumbnails) behind a
+// small interface so the backend can be a local directory or any
+// S3-compatible object store (AWS S3, MinIO, ...).
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned by Open when the key does not exist.
+var ErrNotFound = errors.New("object not found")
+
+type Storage interface {
+	// Put stores size bytes from r under key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the content stored under key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a direct download URL valid for ttl, or "" if the
+	// backend can't serve files itself and they must be streamed by us. The
+	// response to it carries the given Content-Type and Content-Disposition
+	// headers, whatever was stored with the object.
+	SignedURL(ctx context.Context, key string, ttl time.Duration, contentType, disposition string) (string, error)
+}
+
+// FromEnv builds the storage configured by STORAGE_DRIVER:
+//   - "local" (default): files under STORAGE_LOCAL_DIR (default ./uploads)
+//   - "s3": bucket S3_BUCKET on S3_ENDPOINT with S3_ACCESS_KEY /
+//     S3_SECRET_KEY; S3_USE_SSL=false for plain http (e.g. a local MinIO),
+//     S3_REGION optional
+func FromEnv() (Storage, error) {
+	switch os.Getenv("STORAGE_DRIVER") {
+	case "", "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "./uploads"
+		}
+		return NewLocal(dir)
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			UseSSL:    os.Getenv("S3_USE_SSL") != "false",
+		})
+	default:
+		return nil, errors.New("unknown STORAGE_DRIVER " + os.Getenv("STORAGE_DRIVER"))
+	}
+}